@@ -30,6 +30,9 @@ export class SftpServiceStack extends cdk.Stack {
       ],
     });
 
+    // Subnets of the internet-facing NLB, the only addresses allowed to send PROXY protocol headers
+    const nlbSubnets = vpc.selectSubnets({ subnetType: ec2.SubnetType.PUBLIC });
+
     // ECS Cluster for Fargate
     const cluster = new ecs.Cluster(this, 'SftpCluster', {
       vpc,
@@ -112,9 +115,19 @@ export class SftpServiceStack extends cdk.Stack {
       }),
       environment: {
         FUTUR_API_URL: 'https://test.hyd.fi',
+        // The RSA key on EFS is the one clients already know. Further keys are created on EFS
+        // (ssh-keygen) before they are listed here, a missing key stops the task instead of
+        // silently giving the service a new identity.
         SFTP_HOST_KEY_PATH: '/data/host_key',
+        SFTP_HOST_KEY_GENERATE: 'false',
         SFTP_PORT: '22',
+        SFTP_SHUTDOWN_TIMEOUT: '25s',
+        // The NLB connects from its nodes in the public subnets and sends PROXY protocol v2 headers,
+        // other hosts in the VPC must not be able to claim a client address
+        SFTP_PROXY_TRUSTED_CIDRS: nlbSubnets.subnets.map((subnet) => subnet.ipv4CidrBlock).join(','),
       },
+      // Leave time for transfers to finish after SIGTERM, see SFTP_SHUTDOWN_TIMEOUT
+      stopTimeout: cdk.Duration.seconds(30),
     });
 
     // Add EFS mount point to container
@@ -168,6 +181,7 @@ export class SftpServiceStack extends cdk.Stack {
     const nlb = new elbv2.NetworkLoadBalancer(this, 'SftpLoadBalancer', {
       vpc,
       internetFacing: true,
+      vpcSubnets: { subnets: nlbSubnets.subnets },
       loadBalancerName: 'sftp-service-nlb',
     });
 
@@ -177,6 +191,8 @@ export class SftpServiceStack extends cdk.Stack {
       protocol: elbv2.Protocol.TCP,
       vpc,
       targetType: elbv2.TargetType.IP,
+      // Pass the client address to the service, see SFTP_PROXY_TRUSTED_CIDRS
+      proxyProtocolV2: true,
       healthCheck: {
         protocol: elbv2.Protocol.TCP,
         port: '22',
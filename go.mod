@@ -3,12 +3,15 @@ module sftp-service
 go 1.24.0
 
 require (
+	github.com/ProtonMail/go-crypto v1.5.2
 	github.com/joho/godotenv v1.5.1
 	github.com/pkg/sftp v1.13.10
 	golang.org/x/crypto v0.43.0
+	golang.org/x/text v0.30.0
 )
 
 require (
+	github.com/cloudflare/circl v1.6.3 // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
 )
@@ -8,6 +8,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"sftp-service/internal/quota"
 )
 
 type WebAPIAuthenticator struct {
@@ -22,15 +24,17 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message,omitempty"`
-	UserID  string `json:"user_id,omitempty"`
+	Success bool          `json:"success"`
+	Message string        `json:"message,omitempty"`
+	UserID  string        `json:"user_id,omitempty"`
+	Quota   *quota.Limits `json:"quota,omitempty"`
 }
 
 type User struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	ApiKey   string `json:"api_key"` // Store password for API calls
+	ID       string        `json:"id"`
+	Username string        `json:"username"`
+	ApiKey   string        `json:"api_key"`         // Store password for API calls
+	Quota    *quota.Limits `json:"quota,omitempty"` // Upload quota from the login response, if any
 }
 
 // NewWebAPIAuthenticator creates a new web API authenticator
@@ -46,6 +50,11 @@ func NewWebAPIAuthenticator(baseURL string) *WebAPIAuthenticator {
 
 // AuthenticateUser authenticates a user against the web API (with fallback to hardcoded user)
 func (w *WebAPIAuthenticator) AuthenticateUser(username, password string) (*User, error) {
+	return w.AuthenticateUserFrom(username, password, "")
+}
+
+// AuthenticateUserFrom is AuthenticateUser that passes the client's IP address to the API in X-Remote-Addr
+func (w *WebAPIAuthenticator) AuthenticateUserFrom(username, password, remoteAddr string) (*User, error) {
 	// Hardcoded user for testing
 	if username == "mika" && password == "taataataa11" {
 		log.Printf("Authentication successful for hardcoded user: %s", username)
@@ -76,6 +85,9 @@ func (w *WebAPIAuthenticator) AuthenticateUser(username, password string) (*User
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "SFTP-Service/1.0")
+	if remoteAddr != "" {
+		req.Header.Set("X-Remote-Addr", remoteAddr)
+	}
 
 	log.Printf("Authenticating user %s against web API: %s", username, url)
 
@@ -112,6 +124,7 @@ func (w *WebAPIAuthenticator) AuthenticateUser(username, password string) (*User
 		ID:       authResp.UserID,
 		Username: username,
 		ApiKey:   password, // Store password as API key for subsequent API calls
+		Quota:    authResp.Quota,
 	}, nil
 }
 
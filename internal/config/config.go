@@ -1,16 +1,70 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/pgp"
+	"sftp-service/internal/pricelist"
+	"sftp-service/internal/proxyproto"
+	"sftp-service/internal/quota"
 )
 
 type Config struct {
-	FuturAPIURL     string
-	SFTPHostKeyPath string
-	SFTPPort        string
+	FuturAPIURL         string
+	SFTPHostKeyPaths    []string
+	SFTPHostKeys        string
+	SFTPHostKeyGenerate bool
+	SFTPHostCerts       []string
+	SFTPPort            string
+	InboundFolders      []inbound.Folder
+	AuditDir            string
+	SpoolDir            string
+	SpoolRetention      time.Duration
+	ListingWindow       time.Duration
+	ListingStatus       string
+	PGPKeyFile          string
+	PGPPassphrase       string
+	PricelistTTL        time.Duration
+	PricelistDir        string
+	PricelistKeep       time.Duration
+	PricelistFormats    *pricelist.Formats
+	ShutdownTimeout     time.Duration
+	MaxConnections      int
+	MaxConnectionsIP    int
+	MaxUserSessions     int
+	MaxChannels         int
+	HandshakeTimeout    time.Duration
+	IdleTimeout         time.Duration
+	MaxSessionDuration  time.Duration
+	KeepaliveInterval   time.Duration
+	KeepaliveCountMax   int
+	CryptoProfile       string
+	CryptoAudit         string
+	KexAlgorithms       []string
+	Ciphers             []string
+	MACs                []string
+	PublicKeyAlgorithms []string
+	ProxyTrusted        []*net.IPNet
+	ProxyHeaderTimeout  time.Duration
+	StatusAddr          string
+	Users               map[string]UserSettings
+}
+
+// UserSettings holds per-user overrides loaded from SFTP_USERS_FILE
+type UserSettings struct {
+	Normalize *inbound.Normalization `json:"normalize,omitempty"`
+	Quota     *quota.Limits          `json:"quota,omitempty"`     // User-wide upload quota, the login response may override it
+	PGP       *pgp.Settings          `json:"pgp,omitempty"`       // Decrypt uploads and encrypt pricelists with the customer's key
+	Pricelist *pricelist.Formats     `json:"pricelist,omitempty"` // Derived pricelist files, replacing SFTP_PRICELIST_FORMATS
 }
 
 // LoadConfig loads configuration from environment variables
@@ -19,9 +73,81 @@ func LoadConfig() (*Config, error) {
 	_ = godotenv.Load()
 
 	config := &Config{
-		FuturAPIURL:     getEnv("FUTUR_API_URL", "http://localhost:3000"),
-		SFTPHostKeyPath: getEnv("SFTP_HOST_KEY_PATH", "./host_key"),
-		SFTPPort:        getEnv("SFTP_PORT", "2222"),
+		FuturAPIURL:   getEnv("FUTUR_API_URL", "http://localhost:3000"),
+		SFTPHostKeys:  getEnv("SFTP_HOST_KEYS", ""),
+		SFTPPort:      getEnv("SFTP_PORT", "2222"),
+		AuditDir:      getEnv("SFTP_AUDIT_DIR", ""),
+		SpoolDir:      getEnv("SFTP_SPOOL_DIR", ""),
+		ListingStatus: getEnv("SFTP_IN_LISTING_STATUS", "suffix"),
+		PGPKeyFile:    getEnv("SFTP_PGP_PRIVATE_KEY_FILE", ""),
+		PGPPassphrase: getEnv("SFTP_PGP_PASSPHRASE", ""),
+		PricelistDir:  getEnv("SFTP_PRICELIST_DIR", ""),
+		StatusAddr:    getEnv("SFTP_STATUS_ADDR", ""),
+		CryptoProfile: getEnv("SFTP_CRYPTO_PROFILE", ""),
+		CryptoAudit:   getEnv("SFTP_CRYPTO_AUDIT_PROFILE", ""),
+	}
+
+	config.SFTPHostKeyPaths = getEnvList("SFTP_HOST_KEY_PATH", "./host_key")
+	config.SFTPHostCerts = getEnvList("SFTP_HOST_CERTIFICATES", "")
+	config.KexAlgorithms = getEnvList("SFTP_KEX_ALGORITHMS", "")
+	config.Ciphers = getEnvList("SFTP_CIPHERS", "")
+	config.MACs = getEnvList("SFTP_MACS", "")
+	config.PublicKeyAlgorithms = getEnvList("SFTP_PUBLIC_KEY_ALGORITHMS", "")
+
+	var err error
+	if config.ProxyTrusted, err = proxyproto.ParseCIDRs(getEnvList("SFTP_PROXY_TRUSTED_CIDRS", "")); err != nil {
+		return nil, fmt.Errorf("SFTP_PROXY_TRUSTED_CIDRS: %w", err)
+	}
+	if config.ProxyHeaderTimeout, err = getEnvDuration("SFTP_PROXY_HEADER_TIMEOUT", proxyproto.DefaultTimeout); err != nil {
+		return nil, err
+	}
+	if config.SFTPHostKeyGenerate, err = getEnvBool("SFTP_HOST_KEY_GENERATE", false); err != nil {
+		return nil, err
+	}
+	if config.SpoolRetention, err = getEnvDuration("SFTP_SPOOL_RETENTION", 7*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.ListingWindow, err = getEnvDuration("SFTP_IN_LISTING_WINDOW", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.PricelistTTL, err = getEnvDuration("SFTP_PRICELIST_CACHE_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.PricelistKeep, err = getEnvDuration("SFTP_PRICELIST_RETENTION", 90*24*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.ShutdownTimeout, err = getEnvDuration("SFTP_SHUTDOWN_TIMEOUT", 25*time.Second); err != nil {
+		return nil, err
+	}
+	if config.MaxConnections, err = getEnvInt("SFTP_MAX_CONNECTIONS", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxConnectionsIP, err = getEnvInt("SFTP_MAX_CONNECTIONS_PER_IP", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxUserSessions, err = getEnvInt("SFTP_MAX_SESSIONS_PER_USER", 0); err != nil {
+		return nil, err
+	}
+	if config.MaxChannels, err = getEnvInt("SFTP_MAX_CHANNELS_PER_CONNECTION", 10); err != nil {
+		return nil, err
+	}
+	if config.HandshakeTimeout, err = getEnvDuration("SFTP_HANDSHAKE_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.IdleTimeout, err = getEnvDuration("SFTP_IDLE_TIMEOUT", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.MaxSessionDuration, err = getEnvDuration("SFTP_MAX_SESSION_DURATION", 0); err != nil {
+		return nil, err
+	}
+	if config.KeepaliveInterval, err = getEnvDuration("SFTP_KEEPALIVE_INTERVAL", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if config.KeepaliveCountMax, err = getEnvInt("SFTP_KEEPALIVE_COUNT_MAX", 3); err != nil {
+		return nil, err
+	}
+	if config.ListingStatus != "suffix" && config.ListingStatus != "files" {
+		return nil, fmt.Errorf("SFTP_IN_LISTING_STATUS must be suffix or files")
 	}
 
 	// Validate required configuration
@@ -29,12 +155,132 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("FUTUR_API_URL is required")
 	}
 
+	// Inbound folders are optional, the default is a single /in folder
+	if foldersFile := getEnv("SFTP_INBOUND_FOLDERS_FILE", ""); foldersFile != "" {
+		folders, err := inbound.LoadFolders(foldersFile)
+		if err != nil {
+			return nil, err
+		}
+		config.InboundFolders = folders
+	} else {
+		config.InboundFolders = inbound.DefaultFolders()
+	}
+
+	// Derived pricelist files offered to users without their own formats
+	config.PricelistFormats = &pricelist.Formats{}
+	for _, format := range strings.Split(getEnv("SFTP_PRICELIST_FORMATS", "csv,json"), ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			config.PricelistFormats.Formats = append(config.PricelistFormats.Formats, pricelist.ConverterConfig{Type: format})
+		}
+	}
+	if err := config.PricelistFormats.Load(); err != nil {
+		return nil, fmt.Errorf("SFTP_PRICELIST_FORMATS: %w", err)
+	}
+
+	// Per-user settings are optional
+	if usersFile := getEnv("SFTP_USERS_FILE", ""); usersFile != "" {
+		users, err := loadUserSettings(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Users = users
+	}
+
+	// PGP settings need the service key to decrypt uploads
+	for username, settings := range config.Users {
+		if settings.PGP != nil && config.PGPKeyFile == "" {
+			return nil, fmt.Errorf("user %s has PGP settings but SFTP_PGP_PRIVATE_KEY_FILE is not set", username)
+		}
+	}
+
 	return config, nil
 }
 
+// loadUserSettings reads per-user settings keyed by username from a JSON file
+func loadUserSettings(filename string) (map[string]UserSettings, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user settings: %w", err)
+	}
+
+	var users map[string]UserSettings
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse user settings: %w", err)
+	}
+
+	for username, settings := range users {
+		if settings.Normalize != nil {
+			if err := settings.Normalize.Validate(); err != nil {
+				return nil, fmt.Errorf("user %s: %w", username, err)
+			}
+		}
+		if settings.PGP != nil {
+			if err := settings.PGP.Load(); err != nil {
+				return nil, fmt.Errorf("user %s: %w", username, err)
+			}
+		}
+		if settings.Pricelist != nil {
+			if err := settings.Pricelist.Load(); err != nil {
+				return nil, fmt.Errorf("user %s: %w", username, err)
+			}
+		}
+	}
+
+	return users, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: invalid count %q", key, value)
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean %q", key, value)
+	}
+	return b, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists || value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q: %w", key, value, err)
+	}
+	return duration, nil
+}
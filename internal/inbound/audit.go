@@ -0,0 +1,51 @@
+package inbound
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Archive keeps the original bytes of every upload for audits, before any normalization
+type Archive struct {
+	dir    string
+	logger *log.Logger // Standard logger when nil
+}
+
+// NewArchive creates an archive rooted at dir
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+// SetLogger sends the archive's log output to logger instead of the standard logger
+func (a *Archive) SetLogger(logger *log.Logger) {
+	a.logger = logger
+}
+
+// Save stores the original upload as <dir>/<user>/<date>/<time>_<filename> and returns its path
+func (a *Archive) Save(username, filename string, data []byte, at time.Time) (string, error) {
+	at = at.UTC()
+	dir := filepath.Join(a.dir, filepath.Base(username), at.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	path := filepath.Join(dir, at.Format("150405.000000")+"_"+filepath.Base(filename))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write audit copy: %w", err)
+	}
+
+	a.logf("Archived original upload %s/%s to %s", username, filename, path)
+	return path, nil
+}
+
+// logf logs through the configured logger
+func (a *Archive) logf(format string, args ...interface{}) {
+	if a.logger != nil {
+		a.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
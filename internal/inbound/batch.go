@@ -0,0 +1,109 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultBatchTimeout is how long uploads wait for their trigger when no timeout is configured
+const defaultBatchTimeout = time.Hour
+
+// BatchPolicy holds a folder's uploads until a trigger file arrives and then delivers them together
+type BatchPolicy struct {
+	Triggers []string `json:"triggers,omitempty"` // Trigger file suffixes such as ".done" or ".ok"
+	Manifest string   `json:"manifest,omitempty"` // Pattern for manifest files listing the batch, such as "*.manifest"
+	Timeout  Duration `json:"timeout,omitempty"`  // Held files are rejected when no trigger arrives in time, default 1h
+	Endpoint string   `json:"endpoint,omitempty"` // Where committed batches are sent, default <folder endpoint>/batch
+}
+
+// compile validates the policy and fills in defaults. Batches have their own
+// endpoint since their request differs from the folder's single uploads.
+func (p *BatchPolicy) compile(folderEndpoint string) error {
+	if len(p.Triggers) == 0 && p.Manifest == "" {
+		return fmt.Errorf("batch mode needs trigger suffixes or a manifest pattern")
+	}
+
+	for i, suffix := range p.Triggers {
+		suffix = strings.ToLower(suffix)
+		if !strings.HasPrefix(suffix, ".") {
+			suffix = "." + suffix
+		}
+		p.Triggers[i] = suffix
+	}
+
+	if p.Manifest != "" {
+		if _, err := path.Match(p.Manifest, ""); err != nil {
+			return fmt.Errorf("invalid manifest pattern %q: %w", p.Manifest, err)
+		}
+	}
+
+	if p.Timeout <= 0 {
+		p.Timeout = Duration(defaultBatchTimeout)
+	}
+
+	switch {
+	case p.Endpoint == "":
+		p.Endpoint = strings.TrimSuffix(folderEndpoint, "/") + "/batch"
+	case !strings.HasPrefix(p.Endpoint, "/"):
+		p.Endpoint = "/" + p.Endpoint
+	}
+	if p.Endpoint == folderEndpoint {
+		return fmt.Errorf("batch endpoint must differ from the folder's endpoint %s", folderEndpoint)
+	}
+	return nil
+}
+
+// TriggerBase returns the base name of the batch a trigger file commits, such as
+// "order123" for "order123.done"
+func (p *BatchPolicy) TriggerBase(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	for _, suffix := range p.Triggers {
+		if strings.HasSuffix(lower, suffix) && len(name) > len(suffix) {
+			return name[:len(name)-len(suffix)], true
+		}
+	}
+	return "", false
+}
+
+// IsManifest reports whether name is a manifest file
+func (p *BatchPolicy) IsManifest(name string) bool {
+	if p.Manifest == "" {
+		return false
+	}
+	matched, _ := path.Match(p.Manifest, name)
+	return matched
+}
+
+// IsControlFile reports whether name is a trigger or manifest rather than batch content
+func (p *BatchPolicy) IsControlFile(name string) bool {
+	if p == nil {
+		return false
+	}
+	_, trigger := p.TriggerBase(name)
+	return trigger || p.IsManifest(name)
+}
+
+// BatchMember reports whether a held file belongs to the batch committed by a trigger
+// with the given base name: "order123.csv" and "order123_lines.csv" belong to "order123"
+func BatchMember(base, name string) bool {
+	return name == base || strings.HasPrefix(name, base+".") || strings.HasPrefix(name, base+"_")
+}
+
+// ParseManifest returns the file names listed in a manifest, one per line.
+// Empty lines and lines starting with '#' are skipped.
+func ParseManifest(data []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, path.Base(line))
+	}
+	return names
+}
@@ -0,0 +1,99 @@
+package inbound
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchPolicyCompile(t *testing.T) {
+	policy := &BatchPolicy{Triggers: []string{"DONE", ".ok"}}
+	if err := policy.compile("/api/futur/order"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(policy.Triggers, []string{".done", ".ok"}) {
+		t.Errorf("Triggers = %v", policy.Triggers)
+	}
+	if policy.Endpoint != "/api/futur/order/batch" {
+		t.Errorf("default endpoint = %s", policy.Endpoint)
+	}
+	if time.Duration(policy.Timeout) != defaultBatchTimeout {
+		t.Errorf("default timeout = %s", time.Duration(policy.Timeout))
+	}
+
+	policy = &BatchPolicy{Manifest: "*.manifest", Endpoint: "api/futur/batch"}
+	if err := policy.compile("/api/futur/order"); err != nil || policy.Endpoint != "/api/futur/batch" {
+		t.Errorf("compile = %v, endpoint %s", err, policy.Endpoint)
+	}
+
+	for _, invalid := range []BatchPolicy{
+		{},
+		{Manifest: "[.manifest"},
+		{Triggers: []string{".done"}, Endpoint: "/api/futur/order"},
+	} {
+		if err := invalid.compile("/api/futur/order"); err == nil {
+			t.Errorf("compile accepted %+v", invalid)
+		}
+	}
+}
+
+func TestBatchControlFiles(t *testing.T) {
+	policy := &BatchPolicy{Triggers: []string{".done"}, Manifest: "*.manifest"}
+	if err := policy.compile("/api/futur/order"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		base     string
+		trigger  bool
+		manifest bool
+	}{
+		{"order123.done", "order123", true, false},
+		{"order123.DONE", "order123", true, false},
+		{".done", "", false, false}, // A trigger needs a base name
+		{"order123.csv", "", false, false},
+		{"week12.manifest", "", false, true},
+	}
+	for _, tt := range tests {
+		base, trigger := policy.TriggerBase(tt.name)
+		if base != tt.base || trigger != tt.trigger {
+			t.Errorf("TriggerBase(%q) = %q, %v", tt.name, base, trigger)
+		}
+		if got := policy.IsManifest(tt.name); got != tt.manifest {
+			t.Errorf("IsManifest(%q) = %v", tt.name, got)
+		}
+		if got := policy.IsControlFile(tt.name); got != (tt.trigger || tt.manifest) {
+			t.Errorf("IsControlFile(%q) = %v", tt.name, got)
+		}
+	}
+
+	var none *BatchPolicy
+	if none.IsControlFile("order123.done") {
+		t.Error("folder without batch mode has control files")
+	}
+}
+
+func TestBatchMember(t *testing.T) {
+	for name, want := range map[string]bool{
+		"order123":           true,
+		"order123.csv":       true,
+		"order123_lines.csv": true,
+		"order1234.csv":      false,
+		"xorder123.csv":      false,
+	} {
+		if got := BatchMember("order123", name); got != want {
+			t.Errorf("BatchMember(order123, %s) = %v", name, got)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	manifest := "# week 12\r\norder1.csv\n\n  order2.csv  \n../other/order3.csv\n"
+	if got, want := ParseManifest([]byte(manifest)), []string{"order1.csv", "order2.csv", "order3.csv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseManifest = %v, want %v", got, want)
+	}
+	if got := ParseManifest([]byte("# nothing\n")); len(got) != 0 {
+		t.Errorf("ParseManifest of comments = %v", got)
+	}
+}
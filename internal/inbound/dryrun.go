@@ -0,0 +1,43 @@
+package inbound
+
+import "fmt"
+
+// DryRunPolicy turns a folder into a test folder: uploads go through the full
+// pipeline and produce a report, but are never delivered as real orders
+type DryRunPolicy struct {
+	ValidateAs string `json:"validate_as,omitempty"` // Folder whose rules are applied, default the folder's own
+	Endpoint   string `json:"endpoint,omitempty"`    // The API's dry-run endpoint, if it has one
+}
+
+// checkDryRun makes sure every dry-run folder refers to a real folder for its rules
+func checkDryRun(folders []Folder) error {
+	byPath := make(map[string]*Folder)
+	for i := range folders {
+		byPath[folders[i].Path] = &folders[i]
+	}
+
+	for _, folder := range folders {
+		if folder.DryRun == nil || folder.DryRun.ValidateAs == "" {
+			continue
+		}
+		target, ok := byPath[folder.DryRun.ValidateAs]
+		if !ok {
+			return fmt.Errorf("inbound folder %s validates as unknown folder %s", folder.Path, folder.DryRun.ValidateAs)
+		}
+		if target.DryRun != nil {
+			return fmt.Errorf("inbound folder %s cannot validate as another dry-run folder", folder.Path)
+		}
+	}
+	return nil
+}
+
+// Rules returns the folder whose naming, normalization and validation rules
+// apply to uploads in folder
+func (r *Router) Rules(folder *Folder) *Folder {
+	if folder.DryRun != nil && folder.DryRun.ValidateAs != "" {
+		if target, ok := r.folders[folder.DryRun.ValidateAs]; ok {
+			return target
+		}
+	}
+	return folder
+}
@@ -0,0 +1,224 @@
+package inbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"sftp-service/internal/quota"
+)
+
+// Payload envelopes supported when forwarding a file to the API
+const (
+	EnvelopeOrder  = "order"  // JSON OrderRequest with the content as a string
+	EnvelopeBase64 = "base64" // JSON OrderRequest with the content base64 encoded
+	EnvelopeRaw    = "raw"    // Raw file bytes, metadata in headers
+)
+
+// DefaultMaxSize is the size limit of folders that do not set max_size, uploads are buffered in memory
+const DefaultMaxSize = 100 * 1024
+
+// Folder describes an inbound directory and where its uploads are delivered
+type Folder struct {
+	Path              string            `json:"path"`
+	Endpoint          string            `json:"endpoint"`
+	MaxSize           int64             `json:"max_size"` // DefaultMaxSize when zero
+	AllowedExtensions []string          `json:"allowed_extensions,omitempty"`
+	Envelope          string            `json:"envelope,omitempty"`
+	EnvelopeVersion   int               `json:"envelope_version,omitempty"` // 1 sends the legacy envelope without metadata
+	Validators        []ValidatorConfig `json:"validators,omitempty"`
+	Normalize         *Normalization    `json:"normalize,omitempty"`
+	Quota             *quota.Limits     `json:"quota,omitempty"` // Per-user limits for uploads to this folder
+	Naming            *NamingPolicy     `json:"naming,omitempty"`
+	Batch             *BatchPolicy      `json:"batch,omitempty"`   // Hold uploads until a trigger file commits them
+	DryRun            *DryRunPolicy     `json:"dry_run,omitempty"` // Only validate uploads and report the result
+
+	validators []boundValidator
+}
+
+// DefaultFolders returns the /in folder and its /in/validate test folder used when nothing is configured
+func DefaultFolders() []Folder {
+	return []Folder{
+		{
+			Path:      "/in",
+			Endpoint:  "/api/futur/order",
+			MaxSize:   DefaultMaxSize,
+			Envelope:  EnvelopeOrder,
+			Normalize: &Normalization{Encoding: EncodingAuto, StripBOM: true},
+		},
+		{
+			Path:    "/in/validate",
+			MaxSize: DefaultMaxSize,
+			DryRun:  &DryRunPolicy{ValidateAs: "/in"},
+		},
+	}
+}
+
+// LoadFolders reads inbound folder definitions from a JSON file
+func LoadFolders(filename string) ([]Folder, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inbound folders: %w", err)
+	}
+
+	var folders []Folder
+	if err := json.Unmarshal(data, &folders); err != nil {
+		return nil, fmt.Errorf("failed to parse inbound folders: %w", err)
+	}
+
+	for i := range folders {
+		if err := folders[i].normalize(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkDryRun(folders); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
+// normalize cleans up and validates a folder definition
+func (f *Folder) normalize() error {
+	f.Path = path.Clean("/" + f.Path)
+	if f.Path != "/in" && path.Dir(f.Path) != "/in" {
+		return fmt.Errorf("inbound folder %s must be /in or directly below it", f.Path)
+	}
+
+	// Dry-run folders never deliver, they only need an endpoint for the API's dry-run mode
+	if f.DryRun != nil {
+		if f.Batch != nil {
+			return fmt.Errorf("inbound folder %s: dry-run folders cannot use batch mode", f.Path)
+		}
+		if f.DryRun.ValidateAs != "" {
+			f.DryRun.ValidateAs = path.Clean("/" + f.DryRun.ValidateAs)
+		}
+		if f.DryRun.Endpoint != "" && !strings.HasPrefix(f.DryRun.Endpoint, "/") {
+			f.DryRun.Endpoint = "/" + f.DryRun.Endpoint
+		}
+		f.Endpoint = ""
+	} else if f.Endpoint == "" {
+		return fmt.Errorf("inbound folder %s has no endpoint", f.Path)
+	}
+	if f.Endpoint != "" && !strings.HasPrefix(f.Endpoint, "/") {
+		f.Endpoint = "/" + f.Endpoint
+	}
+
+	if f.MaxSize <= 0 {
+		f.MaxSize = DefaultMaxSize
+	}
+
+	switch f.Envelope {
+	case "":
+		f.Envelope = EnvelopeOrder
+	case EnvelopeOrder, EnvelopeBase64, EnvelopeRaw:
+	default:
+		return fmt.Errorf("inbound folder %s has unknown envelope %q", f.Path, f.Envelope)
+	}
+
+	switch f.EnvelopeVersion {
+	case 0, 1, 2:
+	default:
+		return fmt.Errorf("inbound folder %s has unknown envelope version %d", f.Path, f.EnvelopeVersion)
+	}
+
+	for i, ext := range f.AllowedExtensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		f.AllowedExtensions[i] = ext
+	}
+
+	if f.Normalize != nil {
+		if err := f.Normalize.Validate(); err != nil {
+			return fmt.Errorf("inbound folder %s: %w", f.Path, err)
+		}
+	}
+
+	if f.Naming != nil {
+		if err := f.Naming.compile(); err != nil {
+			return fmt.Errorf("inbound folder %s: %w", f.Path, err)
+		}
+	}
+
+	if f.Batch != nil {
+		if f.Envelope == EnvelopeRaw {
+			return fmt.Errorf("inbound folder %s: batch mode needs the order or base64 envelope", f.Path)
+		}
+		if err := f.Batch.compile(f.Endpoint); err != nil {
+			return fmt.Errorf("inbound folder %s: %w", f.Path, err)
+		}
+	}
+
+	validators, err := buildValidators(f.Validators)
+	if err != nil {
+		return fmt.Errorf("inbound folder %s: %w", f.Path, err)
+	}
+	f.validators = validators
+
+	return nil
+}
+
+// ExtensionAllowed checks the filename against the folder's allowed extensions
+func (f *Folder) ExtensionAllowed(filename string) bool {
+	if len(f.AllowedExtensions) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(path.Ext(filename))
+	for _, allowed := range f.AllowedExtensions {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Router maps upload paths to inbound folders
+type Router struct {
+	folders map[string]*Folder
+}
+
+// NewRouter creates a router for the given folders
+func NewRouter(folders []Folder) *Router {
+	r := &Router{folders: make(map[string]*Folder)}
+	for i := range folders {
+		r.folders[folders[i].Path] = &folders[i]
+	}
+	return r
+}
+
+// FolderFor returns the folder a file path would be uploaded to
+func (r *Router) FolderFor(filePath string) (*Folder, bool) {
+	folder, ok := r.folders[path.Dir(filePath)]
+	return folder, ok
+}
+
+// Folder returns the configured folder at dir
+func (r *Router) Folder(dir string) (*Folder, bool) {
+	folder, ok := r.folders[dir]
+	return folder, ok
+}
+
+// IsFolder checks if the path is a configured inbound folder
+func (r *Router) IsFolder(dir string) bool {
+	_, ok := r.folders[dir]
+	return ok
+}
+
+// Subfolders returns the names of configured folders directly below dir
+func (r *Router) Subfolders(dir string) []string {
+	var names []string
+	for p := range r.folders {
+		if p != dir && path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,231 @@
+package inbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Collision handling modes for uploads whose name was already used
+const (
+	CollisionAllow     = "allow"     // Forward the file under the same name (default)
+	CollisionReject    = "reject"    // Refuse the upload
+	CollisionSuffix    = "suffix"    // Rename to name_1.ext, name_2.ext, ...
+	CollisionTimestamp = "timestamp" // Rename to name_20060102T150405Z.ext
+)
+
+// Duration is a time.Duration read from JSON as a string such as "24h"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf(`duration must be a string such as "24h"`)
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// NamingPolicy configures which file names an inbound folder accepts and how they are cleaned up
+type NamingPolicy struct {
+	Patterns        []string `json:"patterns,omitempty"`         // At least one must match the whole name
+	MaxLength       int      `json:"max_length,omitempty"`       // In characters, after normalization
+	NFC             bool     `json:"nfc,omitempty"`              // Apply Unicode NFC normalization
+	Sanitize        bool     `json:"sanitize,omitempty"`         // Replace unsafe characters with '_'
+	Collision       string   `json:"collision,omitempty"`        // allow, reject, suffix or timestamp
+	CollisionWindow Duration `json:"collision_window,omitempty"` // Zero checks collisions within the session only
+
+	patterns []*regexp.Regexp
+}
+
+// compile validates the policy and prepares its patterns
+func (p *NamingPolicy) compile() error {
+	switch p.Collision {
+	case "":
+		p.Collision = CollisionAllow
+	case CollisionAllow, CollisionReject, CollisionSuffix, CollisionTimestamp:
+	default:
+		return fmt.Errorf("unknown collision mode %q", p.Collision)
+	}
+
+	p.patterns = nil
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid file name pattern %q: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return nil
+}
+
+// NamingError is returned when an upload's file name breaks the folder's policy
+type NamingError struct {
+	Name   string
+	Reason string
+}
+
+func (e *NamingError) Error() string {
+	return fmt.Sprintf("file name %q rejected: %s", e.Name, e.Reason)
+}
+
+// CleanName applies normalization, sanitization and the length and pattern rules.
+// It returns the name the file should be delivered under.
+func (p *NamingPolicy) CleanName(name string) (string, error) {
+	if p == nil {
+		return name, nil
+	}
+
+	if !utf8.ValidString(name) {
+		return "", &NamingError{Name: name, Reason: "name is not valid UTF-8"}
+	}
+
+	cleaned := name
+	if p.NFC {
+		cleaned = norm.NFC.String(cleaned)
+	}
+	if p.Sanitize {
+		cleaned = sanitizeName(cleaned)
+	}
+
+	if cleaned == "" {
+		return "", &NamingError{Name: name, Reason: "name is empty"}
+	}
+	if p.MaxLength > 0 && utf8.RuneCountInString(cleaned) > p.MaxLength {
+		return "", &NamingError{Name: name, Reason: fmt.Sprintf("longer than %d characters", p.MaxLength)}
+	}
+
+	if len(p.patterns) > 0 {
+		matched := false
+		for _, re := range p.patterns {
+			if re.MatchString(cleaned) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", &NamingError{Name: name, Reason: fmt.Sprintf("does not match the allowed patterns (%s)", strings.Join(p.Patterns, ", "))}
+		}
+	}
+
+	return cleaned, nil
+}
+
+// sanitizeName replaces characters that are unsafe in file names on common
+// systems with '_' and trims leading dots and surrounding spaces
+func sanitizeName(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r), r == unicode.ReplacementChar:
+			return '_'
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		case unicode.IsSpace(r) && r != ' ':
+			return '_'
+		}
+		return r
+	}, name)
+
+	cleaned = strings.TrimSpace(cleaned)
+	cleaned = strings.TrimLeft(cleaned, ".")
+	cleaned = strings.TrimRight(cleaned, ". ")
+	return cleaned
+}
+
+// ResolveCollision picks the delivered name for an upload according to the
+// policy. taken reports whether a name is already in use.
+func (p *NamingPolicy) ResolveCollision(name string, now time.Time, taken func(string) bool) (string, error) {
+	mode := CollisionAllow
+	if p != nil {
+		mode = p.Collision
+	}
+
+	if mode == CollisionAllow || !taken(name) {
+		return name, nil
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	switch mode {
+	case CollisionReject:
+		return "", &NamingError{Name: name, Reason: "a file with this name was already uploaded"}
+	case CollisionTimestamp:
+		candidate := fmt.Sprintf("%s_%s%s", base, now.UTC().Format("20060102T150405Z"), ext)
+		if !taken(candidate) {
+			return candidate, nil
+		}
+		base = strings.TrimSuffix(candidate, ext)
+	}
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !taken(candidate) {
+			return candidate, nil
+		}
+	}
+}
+
+// NameRegistry remembers recently used upload names per user and folder
+type NameRegistry struct {
+	mu    sync.Mutex
+	names map[string]time.Time // Expiry per name, zero never expires
+}
+
+// NewNameRegistry creates an empty registry
+func NewNameRegistry() *NameRegistry {
+	return &NameRegistry{names: make(map[string]time.Time)}
+}
+
+func nameKey(username, folder, name string) string {
+	return username + "\x00" + folder + "\x00" + name
+}
+
+// Claim resolves a collision for name under the policy and reserves the
+// resulting name until expires. A zero expiry keeps it for the lifetime of
+// the registry.
+func (r *NameRegistry) Claim(username, folder, name string, policy *NamingPolicy, now, expires time.Time) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Forget expired names so the registry does not grow without bounds
+	for key, at := range r.names {
+		if !at.IsZero() && !now.Before(at) {
+			delete(r.names, key)
+		}
+	}
+
+	resolved, err := policy.ResolveCollision(name, now, func(candidate string) bool {
+		_, ok := r.names[nameKey(username, folder, candidate)]
+		return ok
+	})
+	if err != nil {
+		return "", err
+	}
+
+	r.names[nameKey(username, folder, resolved)] = expires
+	return resolved, nil
+}
+
+// Release forgets a name again, used when the upload did not go through
+func (r *NameRegistry) Release(username, folder, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.names, nameKey(username, folder, name))
+}
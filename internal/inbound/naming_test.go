@@ -0,0 +1,140 @@
+package inbound
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanName(t *testing.T) {
+	policy := &NamingPolicy{
+		Patterns:  []string{`order_\d+\.csv`, `[A-Za-zÄÖäö_]+\.xml`},
+		MaxLength: 20,
+		NFC:       true,
+		Sanitize:  true,
+	}
+	if err := policy.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{"order_1001.csv", "order_1001.csv", ""},
+		{"  ..order_1001.csv. ", "order_1001.csv", ""},
+		{"Tila\u0308us.xml", "Tiläus.xml", ""}, // Decomposed ä is composed before matching
+		{"order_1001.txt", "", "does not match the allowed patterns"},
+		{"tilaus:1.xml", "", "does not match"},
+		{"order_100000000001.csv", "", "longer than 20 characters"},
+		{"...", "", "name is empty"},
+		{"order\xff.csv", "", "not valid UTF-8"},
+	}
+	for _, tt := range tests {
+		got, err := policy.CleanName(tt.name)
+		if tt.wantErr != "" {
+			var namingErr *NamingError
+			if !errors.As(err, &namingErr) || !strings.Contains(namingErr.Reason, tt.wantErr) {
+				t.Errorf("CleanName(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CleanName(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	var none *NamingPolicy
+	if got, err := none.CleanName("any name?.txt"); err != nil || got != "any name?.txt" {
+		t.Errorf("CleanName without policy = %q, %v", got, err)
+	}
+}
+
+func TestNamingPolicyCompile(t *testing.T) {
+	for _, policy := range []NamingPolicy{
+		{Collision: "rename"},
+		{Patterns: []string{`order_(\d+.csv`}},
+	} {
+		if err := policy.compile(); err == nil {
+			t.Errorf("compile accepted %+v", policy)
+		}
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	now := time.Date(2026, 3, 4, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		mode    string
+		taken   []string
+		want    string
+		wantErr bool
+	}{
+		{CollisionAllow, []string{"order.csv"}, "order.csv", false},
+		{CollisionReject, nil, "order.csv", false},
+		{CollisionReject, []string{"order.csv"}, "", true},
+		{CollisionSuffix, []string{"order.csv"}, "order_1.csv", false},
+		{CollisionSuffix, []string{"order.csv", "order_1.csv", "order_2.csv"}, "order_3.csv", false},
+		{CollisionTimestamp, []string{"order.csv"}, "order_20260304T102030Z.csv", false},
+		{CollisionTimestamp, []string{"order.csv", "order_20260304T102030Z.csv"}, "order_20260304T102030Z_1.csv", false},
+	}
+	for _, tt := range tests {
+		taken := make(map[string]bool)
+		for _, name := range tt.taken {
+			taken[name] = true
+		}
+		policy := &NamingPolicy{Collision: tt.mode}
+		got, err := policy.ResolveCollision("order.csv", now, func(name string) bool { return taken[name] })
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s with %v = %q, %v; want %q", tt.mode, tt.taken, got, err, tt.want)
+		}
+	}
+}
+
+func TestNameRegistry(t *testing.T) {
+	registry := NewNameRegistry()
+	policy := &NamingPolicy{Collision: CollisionSuffix}
+	now := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+
+	claim := func(user, folder, name string, at time.Time) string {
+		t.Helper()
+		got, err := registry.Claim(user, folder, name, policy, at, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if got := claim("mika", "/in", "order.csv", now); got != "order.csv" {
+		t.Errorf("first claim = %s", got)
+	}
+	if got := claim("mika", "/in", "order.csv", now); got != "order_1.csv" {
+		t.Errorf("second claim = %s", got)
+	}
+	// Names are kept apart per user and folder
+	if got := claim("liisa", "/in", "order.csv", now); got != "order.csv" {
+		t.Errorf("other user's claim = %s", got)
+	}
+	if got := claim("mika", "/in/orders", "order.csv", now); got != "order.csv" {
+		t.Errorf("other folder's claim = %s", got)
+	}
+
+	// A released name is free again
+	registry.Release("mika", "/in", "order_1.csv")
+	if got := claim("mika", "/in", "order.csv", now); got != "order_1.csv" {
+		t.Errorf("claim after release = %s", got)
+	}
+
+	// Expired names are forgotten
+	if got := claim("mika", "/in", "order.csv", expires); got != "order.csv" {
+		t.Errorf("claim after expiry = %s", got)
+	}
+
+	reject := &NamingPolicy{Collision: CollisionReject}
+	if _, err := registry.Claim("mika", "/in", "order.csv", reject, now, time.Time{}); err == nil {
+		t.Error("reject policy accepted a reserved name")
+	}
+}
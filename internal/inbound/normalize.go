@@ -0,0 +1,169 @@
+package inbound
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Source encodings understood by the normalization stage
+const (
+	EncodingAuto        = "auto"
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16       = "utf-16"
+	EncodingISO88591    = "iso-8859-1"
+	EncodingWindows1252 = "windows-1252"
+	EncodingBinary      = "binary"
+)
+
+// Normalization configures how uploaded text files are converted before validation and delivery
+type Normalization struct {
+	Encoding    string `json:"encoding,omitempty"`     // auto, utf-8, utf-16, iso-8859-1 or windows-1252
+	StripBOM    bool   `json:"strip_bom,omitempty"`    // Remove a leading byte order mark
+	LineEndings string `json:"line_endings,omitempty"` // lf or crlf, empty keeps them as is
+}
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// Validate checks the normalization settings
+func (n *Normalization) Validate() error {
+	switch strings.ToLower(n.Encoding) {
+	case "", EncodingAuto, EncodingUTF8, EncodingUTF16, EncodingISO88591, EncodingWindows1252:
+	default:
+		return fmt.Errorf("unknown source encoding %q", n.Encoding)
+	}
+
+	switch strings.ToLower(n.LineEndings) {
+	case "", "lf", "crlf":
+	default:
+		return fmt.Errorf("unknown line ending style %q", n.LineEndings)
+	}
+
+	return nil
+}
+
+// Merge returns n with the settings of override applied on top. Empty fields of
+// override keep the value of n, and a BOM is stripped if either asks for it.
+func (n *Normalization) Merge(override *Normalization) *Normalization {
+	if n == nil {
+		return override
+	}
+	if override == nil {
+		return n
+	}
+
+	merged := *n
+	if override.Encoding != "" {
+		merged.Encoding = override.Encoding
+	}
+	merged.StripBOM = n.StripBOM || override.StripBOM
+	if override.LineEndings != "" {
+		merged.LineEndings = override.LineEndings
+	}
+	return &merged
+}
+
+// Normalize transcodes data to UTF-8 and applies the BOM and line ending settings.
+// It returns the normalized bytes and the detected or declared source encoding.
+// A nil configuration leaves the data untouched.
+func Normalize(n *Normalization, data []byte) ([]byte, string, error) {
+	if n == nil {
+		return data, DetectEncoding(data), nil
+	}
+
+	source := strings.ToLower(n.Encoding)
+	if source == "" || source == EncodingAuto {
+		source = DetectEncoding(data)
+	}
+
+	var decoder *encoding.Decoder
+	switch source {
+	case EncodingBinary:
+		// Never rewrite binary content
+		return data, source, nil
+	case EncodingUTF16:
+		decoder = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case EncodingISO88591:
+		decoder = charmap.ISO8859_1.NewDecoder()
+	case EncodingWindows1252:
+		decoder = charmap.Windows1252.NewDecoder()
+	}
+
+	out := data
+	if decoder != nil {
+		decoded, err := decoder.Bytes(data)
+		if err != nil {
+			return nil, source, fmt.Errorf("failed to convert from %s: %w", source, err)
+		}
+		out = decoded
+	} else if !utf8.Valid(data) {
+		return nil, source, fmt.Errorf("file is not valid %s", source)
+	}
+
+	if n.StripBOM {
+		out = bytes.TrimPrefix(out, utf8BOM)
+	}
+
+	switch strings.ToLower(n.LineEndings) {
+	case "lf":
+		out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+	case "crlf":
+		out = bytes.ReplaceAll(out, []byte("\r\n"), []byte("\n"))
+		out = bytes.ReplaceAll(out, []byte("\n"), []byte("\r\n"))
+	}
+
+	return out, source, nil
+}
+
+// DetectEncoding guesses the encoding of an uploaded file. Valid UTF-8 wins,
+// UTF-16 is recognised by its BOM, and other text is assumed to be Windows-1252
+// when it uses the 0x80-0x9F range (curly quotes, euro sign) and ISO-8859-1 otherwise.
+func DetectEncoding(data []byte) string {
+	if bytes.HasPrefix(data, utf16LEBOM) || bytes.HasPrefix(data, utf16BEBOM) {
+		return EncodingUTF16
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return EncodingBinary
+	}
+
+	if utf8.Valid(data) {
+		return EncodingUTF8
+	}
+
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			return EncodingWindows1252
+		}
+	}
+	return EncodingISO88591
+}
+
+// DetectContentType returns the MIME type of an upload, preferring the well-known
+// order formats by extension and falling back to content sniffing
+func DetectContentType(filename string, data []byte) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return "text/csv"
+	case ".xml":
+		return "application/xml"
+	case ".json":
+		return "application/json"
+	case ".edi", ".edifact":
+		return "application/edifact"
+	case ".txt":
+		return "text/plain"
+	}
+	return http.DetectContentType(data)
+}
@@ -0,0 +1,83 @@
+package inbound
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	auto := &Normalization{Encoding: EncodingAuto, StripBOM: true}
+
+	tests := []struct {
+		name         string
+		settings     *Normalization
+		data         string
+		want         string
+		wantEncoding string
+		wantErr      bool
+	}{
+		{"utf-8 kept", auto, "Hyllyä;12\n", "Hyllyä;12\n", EncodingUTF8, false},
+		{"utf-8 BOM stripped", auto, "\xEF\xBB\xBFa;b\n", "a;b\n", EncodingUTF8, false},
+		{"BOM kept without strip_bom", &Normalization{}, "\xEF\xBB\xBFa", "\xEF\xBB\xBFa", EncodingUTF8, false},
+		{"iso-8859-1 detected", auto, "Hylly\xe4\r\n", "Hyllyä\r\n", EncodingISO88591, false},
+		{"windows-1252 detected", auto, "\x80 12,50", "€ 12,50", EncodingWindows1252, false},
+		{"utf-16 with BOM", auto, "\xFF\xFEa\x00\xe4\x00", "aä", EncodingUTF16, false},
+		{"binary untouched", auto, "PK\x03\x04\x00\r\n", "PK\x03\x04\x00\r\n", EncodingBinary, false},
+		{"declared encoding", &Normalization{Encoding: EncodingISO88591}, "\x80", "\u0080", EncodingISO88591, false},
+		{"declared utf-8 but invalid", &Normalization{Encoding: EncodingUTF8}, "Hylly\xe4", "", EncodingUTF8, true},
+		{"crlf to lf", &Normalization{LineEndings: "lf"}, "a\r\nb\r\n", "a\nb\n", EncodingUTF8, false},
+		{"lf to crlf", &Normalization{LineEndings: "crlf"}, "a\nb\r\n", "a\r\nb\r\n", EncodingUTF8, false},
+		{"nil leaves the data", nil, "Hylly\xe4\r\n", "Hylly\xe4\r\n", EncodingISO88591, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, encoding, err := Normalize(tt.settings, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if encoding != tt.wantEncoding {
+				t.Errorf("encoding = %s, want %s", encoding, tt.wantEncoding)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("Normalize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizationMerge(t *testing.T) {
+	folder := &Normalization{Encoding: EncodingAuto, StripBOM: true}
+
+	tests := []struct {
+		name   string
+		folder *Normalization
+		user   *Normalization
+		want   *Normalization
+	}{
+		{"no user settings", folder, nil, folder},
+		{"no folder settings", nil, &Normalization{LineEndings: "lf"}, &Normalization{LineEndings: "lf"}},
+		{"user adds line endings", folder, &Normalization{LineEndings: "lf"}, &Normalization{Encoding: EncodingAuto, StripBOM: true, LineEndings: "lf"}},
+		{"user declares encoding", folder, &Normalization{Encoding: EncodingWindows1252}, &Normalization{Encoding: EncodingWindows1252, StripBOM: true}},
+		{"neither", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		got := tt.folder.Merge(tt.user)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: Merge = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+	if *folder != (Normalization{Encoding: EncodingAuto, StripBOM: true}) {
+		t.Errorf("Merge changed the folder settings: %+v", folder)
+	}
+}
+
+func TestDefaultFoldersNormalize(t *testing.T) {
+	folders := DefaultFolders()
+	in := folders[0]
+	data, encoding, err := Normalize(in.Normalize, []byte("\xEF\xBB\xBFtuote;hinta\r\n"))
+	if err != nil || encoding != EncodingUTF8 || string(data) != "tuote;hinta\r\n" {
+		t.Errorf("default /in normalization = %q, %s, %v", data, encoding, err)
+	}
+	if data, _, _ := Normalize(in.Normalize, []byte("Hylly\xe4")); string(data) != "Hyllyä" {
+		t.Errorf("default /in leaves ISO-8859-1 as %q", data)
+	}
+}
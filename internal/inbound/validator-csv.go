@@ -0,0 +1,180 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvSchema describes the expected layout of a CSV upload
+type csvSchema struct {
+	Delimiter string      `json:"delimiter"`
+	Header    bool        `json:"header"`
+	MinRows   int         `json:"min_rows"`
+	Columns   []csvColumn `json:"columns"`
+}
+
+type csvColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // string, integer, decimal or date
+	Required bool   `json:"required"`
+	Pattern  string `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+type csvValidator struct {
+	schema    csvSchema
+	delimiter rune
+}
+
+func newCSVValidator(cfg ValidatorConfig) (Validator, error) {
+	if cfg.Schema == "" {
+		return nil, fmt.Errorf("csv validator requires a schema")
+	}
+
+	data, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	v := &csvValidator{delimiter: ';'}
+	if err := json.Unmarshal(data, &v.schema); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	if v.schema.Delimiter != "" {
+		v.delimiter = []rune(v.schema.Delimiter)[0]
+	}
+
+	for i := range v.schema.Columns {
+		column := &v.schema.Columns[i]
+		switch column.Type {
+		case "", "string", "integer", "decimal", "date":
+		default:
+			return nil, fmt.Errorf("column %s has unknown type %q", column.Name, column.Type)
+		}
+		if column.Pattern != "" {
+			pattern, err := regexp.Compile(column.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("column %s has invalid pattern: %w", column.Name, err)
+			}
+			column.pattern = pattern
+		}
+	}
+
+	return v, nil
+}
+
+func (v *csvValidator) Validate(filename string, data []byte) error {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = v.delimiter
+	reader.FieldsPerRecord = -1
+
+	// Map schema columns to record positions, by header name when there is one
+	positions := make([]int, len(v.schema.Columns))
+	for i := range positions {
+		positions[i] = i
+	}
+
+	line := 0
+	if v.schema.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return invalid("csv", "file is empty, expected a header row")
+		}
+		if err != nil {
+			return invalid("csv", "%v", err)
+		}
+		line++
+
+		for i, column := range v.schema.Columns {
+			positions[i] = -1
+			for j, name := range header {
+				if strings.EqualFold(strings.TrimSpace(name), column.Name) {
+					positions[i] = j
+					break
+				}
+			}
+			if positions[i] == -1 && column.Required {
+				return invalid("csv", "header is missing required column %q", column.Name)
+			}
+		}
+	}
+
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return invalid("csv", "%v", err)
+		}
+		line++
+
+		// Skip completely empty lines
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		rows++
+
+		for i, column := range v.schema.Columns {
+			value := ""
+			if positions[i] >= 0 && positions[i] < len(record) {
+				value = strings.TrimSpace(record[positions[i]])
+			}
+
+			if value == "" {
+				if column.Required {
+					return invalid("csv", "line %d: column %q is required", line, column.Name)
+				}
+				continue
+			}
+
+			if err := checkCSVValue(column, value); err != nil {
+				return invalid("csv", "line %d: column %q: %v", line, column.Name, err)
+			}
+		}
+	}
+
+	if rows < v.schema.MinRows {
+		return invalid("csv", "expected at least %d data rows, got %d", v.schema.MinRows, rows)
+	}
+
+	return nil
+}
+
+// checkCSVValue checks a single non-empty value against the column definition
+func checkCSVValue(column csvColumn, value string) error {
+	switch column.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+	case "decimal":
+		// Finnish files use a comma as the decimal separator
+		if _, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64); err != nil {
+			return fmt.Errorf("%q is not a decimal number", value)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			if _, err := time.Parse("2.1.2006", value); err != nil {
+				return fmt.Errorf("%q is not a date (YYYY-MM-DD or D.M.YYYY)", value)
+			}
+		}
+	}
+
+	if column.pattern != nil && !column.pattern.MatchString(value) {
+		return fmt.Errorf("%q does not match pattern %s", value, column.Pattern)
+	}
+
+	return nil
+}
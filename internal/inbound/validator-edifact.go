@@ -0,0 +1,180 @@
+package inbound
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// edifactValidator checks the segment structure of EDIFACT ORDERS interchanges:
+// UNB/UNZ envelope, UNH/UNT message framing with matching references and
+// segment counts, the ORDERS message type and its mandatory BGM, DTM and LIN segments
+type edifactValidator struct{}
+
+func newEDIFACTValidator(cfg ValidatorConfig) (Validator, error) {
+	return &edifactValidator{}, nil
+}
+
+// edifactSyntax holds the separators declared in the UNA service string advice
+type edifactSyntax struct {
+	component  byte
+	element    byte
+	release    byte
+	terminator byte
+}
+
+type edifactSegment struct {
+	tag      string
+	elements [][]string // elements split into components
+}
+
+// element returns a component of a data element, or "" if it is not present
+func (s *edifactSegment) element(i, j int) string {
+	if i >= len(s.elements) || j >= len(s.elements[i]) {
+		return ""
+	}
+	return s.elements[i][j]
+}
+
+func (v *edifactValidator) Validate(filename string, data []byte) error {
+	text := strings.TrimLeft(string(data), " \t\r\n")
+
+	syntax := edifactSyntax{component: ':', element: '+', release: '?', terminator: '\''}
+	if strings.HasPrefix(text, "UNA") {
+		if len(text) < 9 {
+			return invalid("edifact", "truncated UNA service string advice")
+		}
+		syntax = edifactSyntax{component: text[3], element: text[4], release: text[6], terminator: text[8]}
+		text = text[9:]
+	}
+
+	segments, err := splitEDIFACT(text, syntax)
+	if err != nil {
+		return invalid("edifact", "%v", err)
+	}
+	if len(segments) == 0 {
+		return invalid("edifact", "file contains no segments")
+	}
+
+	if segments[0].tag != "UNB" {
+		return invalid("edifact", "interchange must start with UNB, found %s", segments[0].tag)
+	}
+	last := segments[len(segments)-1]
+	if last.tag != "UNZ" {
+		return invalid("edifact", "interchange must end with UNZ, found %s", last.tag)
+	}
+
+	interchangeRef := segments[0].element(4, 0)
+	if last.element(1, 0) != interchangeRef {
+		return invalid("edifact", "UNZ reference %q does not match UNB reference %q", last.element(1, 0), interchangeRef)
+	}
+
+	messages := 0
+	for i := 1; i < len(segments)-1; {
+		unh := segments[i]
+		if unh.tag != "UNH" {
+			return invalid("edifact", "segment %d: expected UNH, found %s", i+1, unh.tag)
+		}
+		if messageType := unh.element(1, 0); messageType != "ORDERS" {
+			return invalid("edifact", "segment %d: message type %q is not ORDERS", i+1, messageType)
+		}
+
+		// Find the matching UNT and collect the segment tags in between
+		start := i
+		tags := make(map[string]int)
+		for i++; i < len(segments)-1 && segments[i].tag != "UNT"; i++ {
+			if segments[i].tag == "UNH" {
+				return invalid("edifact", "segment %d: UNH inside message %q, missing UNT", i+1, unh.element(0, 0))
+			}
+			tags[segments[i].tag]++
+		}
+		if i >= len(segments)-1 {
+			return invalid("edifact", "message %q is missing UNT", unh.element(0, 0))
+		}
+		unt := segments[i]
+
+		if segments[start+1].tag != "BGM" {
+			return invalid("edifact", "message %q: BGM must follow UNH", unh.element(0, 0))
+		}
+		if tags["DTM"] == 0 {
+			return invalid("edifact", "message %q has no DTM segment", unh.element(0, 0))
+		}
+		if tags["LIN"] == 0 {
+			return invalid("edifact", "message %q has no LIN segments", unh.element(0, 0))
+		}
+
+		count, err := strconv.Atoi(unt.element(0, 0))
+		if err != nil || count != i-start+1 {
+			return invalid("edifact", "message %q: UNT segment count %q, expected %d", unh.element(0, 0), unt.element(0, 0), i-start+1)
+		}
+		if unt.element(1, 0) != unh.element(0, 0) {
+			return invalid("edifact", "UNT reference %q does not match UNH reference %q", unt.element(1, 0), unh.element(0, 0))
+		}
+
+		messages++
+		i++
+	}
+
+	if messages == 0 {
+		return invalid("edifact", "interchange contains no messages")
+	}
+	if count, err := strconv.Atoi(last.element(0, 0)); err != nil || count != messages {
+		return invalid("edifact", "UNZ message count %q, expected %d", last.element(0, 0), messages)
+	}
+
+	return nil
+}
+
+// splitEDIFACT splits an interchange into segments, honouring the release character
+func splitEDIFACT(text string, syntax edifactSyntax) ([]edifactSegment, error) {
+	var segments []edifactSegment
+	var elements [][]string
+	var components []string
+	var current strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case syntax.release:
+			i++
+			if i >= len(text) {
+				return nil, fmt.Errorf("release character at end of file")
+			}
+			current.WriteByte(text[i])
+		case syntax.component:
+			components = append(components, current.String())
+			current.Reset()
+		case syntax.element:
+			components = append(components, current.String())
+			elements = append(elements, components)
+			components = nil
+			current.Reset()
+		case syntax.terminator:
+			components = append(components, current.String())
+			elements = append(elements, components)
+
+			tag := strings.TrimSpace(elements[0][0])
+			if len(tag) != 3 {
+				return nil, fmt.Errorf("segment %d: invalid segment tag %q", len(segments)+1, tag)
+			}
+			segments = append(segments, edifactSegment{tag: tag, elements: elements[1:]})
+
+			elements = nil
+			components = nil
+			current.Reset()
+
+			// Segments are often written one per line
+			for i+1 < len(text) && (text[i+1] == '\r' || text[i+1] == '\n') {
+				i++
+			}
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	if strings.TrimSpace(current.String()) != "" || len(elements) > 0 {
+		return nil, fmt.Errorf("last segment is not terminated")
+	}
+
+	return segments, nil
+}
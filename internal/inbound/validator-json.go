@@ -0,0 +1,337 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonValidator checks that uploads are valid JSON and, when a schema is
+// configured, that they follow it. The supported JSON Schema subset is type,
+// properties, required, additionalProperties, items, enum, minLength,
+// maxLength, pattern, minimum, maximum, minItems, maxItems and local $refs.
+type jsonValidator struct {
+	root *jsonSchema
+}
+
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"` // string or list of strings
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+func newJSONValidator(cfg ValidatorConfig) (Validator, error) {
+	v := &jsonValidator{}
+	if cfg.Schema == "" {
+		return v, nil
+	}
+
+	data, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	var root jsonSchema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	if err := root.compile(); err != nil {
+		return nil, err
+	}
+	v.root = &root
+	if err := v.checkRefs(v.root); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// compile prepares the patterns of a schema and all of its subschemas
+func (s *jsonSchema) compile() error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s.Pattern, err)
+		}
+		s.pattern = pattern
+	}
+
+	for _, group := range []map[string]*jsonSchema{s.Properties, s.Definitions, s.Defs} {
+		for _, sub := range group {
+			if err := sub.compile(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Items.compile()
+}
+
+// checkRefs resolves every $ref of a schema and its subschemas, so that
+// unknown and circular refs fail when the schema is loaded
+func (v *jsonValidator) checkRefs(s *jsonSchema) error {
+	if s == nil {
+		return nil
+	}
+	if _, err := v.resolve(s); err != nil {
+		return err
+	}
+	for _, group := range []map[string]*jsonSchema{s.Properties, s.Definitions, s.Defs} {
+		for _, sub := range group {
+			if err := v.checkRefs(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return v.checkRefs(s.Items)
+}
+
+func (v *jsonValidator) Validate(filename string, data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return invalid("json", "not valid JSON: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return invalid("json", "not valid JSON: unexpected data after the top-level value")
+	}
+
+	if v.root == nil {
+		return nil
+	}
+
+	if err := v.validate(v.root, value, "$"); err != nil {
+		return invalid("json", "%v", err)
+	}
+	return nil
+}
+
+// resolve follows a local $ref such as #/definitions/line
+func (v *jsonValidator) resolve(s *jsonSchema) (*jsonSchema, error) {
+	visited := make(map[*jsonSchema]bool)
+	for s.Ref != "" {
+		if visited[s] {
+			return nil, fmt.Errorf("circular $ref %q", s.Ref)
+		}
+		visited[s] = true
+
+		var target *jsonSchema
+		switch {
+		case strings.HasPrefix(s.Ref, "#/definitions/"):
+			target = v.root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+		case strings.HasPrefix(s.Ref, "#/$defs/"):
+			target = v.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		case s.Ref == "#":
+			target = v.root
+		}
+		if target == nil {
+			return nil, fmt.Errorf("unsupported or unknown $ref %q", s.Ref)
+		}
+		s = target
+	}
+	return s, nil
+}
+
+func (v *jsonValidator) validate(s *jsonSchema, value interface{}, where string) error {
+	s, err := v.resolve(s)
+	if err != nil {
+		return err
+	}
+
+	if err := checkJSONType(s.Type, value, where); err != nil {
+		return err
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if jsonEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value %v is not one of the allowed values", where, value)
+		}
+	}
+
+	switch val := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(val)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: string is shorter than %d characters", where, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: string is longer than %d characters", where, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			return fmt.Errorf("%s: %q does not match pattern %s", where, val, s.Pattern)
+		}
+	case json.Number:
+		number, _ := val.Float64()
+		if s.Minimum != nil && number < *s.Minimum {
+			return fmt.Errorf("%s: %v is less than the minimum %v", where, val, *s.Minimum)
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			return fmt.Errorf("%s: %v is greater than the maximum %v", where, val, *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			return fmt.Errorf("%s: array has fewer than %d items", where, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			return fmt.Errorf("%s: array has more than %d items", where, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				if err := v.validate(s.Items, item, fmt.Sprintf("%s[%d]", where, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", where, name)
+			}
+		}
+		for name, item := range val {
+			sub, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", where, name)
+				}
+				continue
+			}
+			if err := v.validate(sub, item, where+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonEqual compares an enum value from the schema with a decoded value.
+// Numbers are equal by value, other values only to values of the same type.
+func jsonEqual(allowed, value interface{}) bool {
+	a, aNumber := jsonNumber(allowed)
+	b, bNumber := jsonNumber(value)
+	if aNumber || bNumber {
+		return aNumber && bNumber && a == b
+	}
+
+	switch av := allowed.(type) {
+	case []interface{}:
+		bv, ok := value.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := value.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, item := range av {
+			other, ok := bv[key]
+			if !ok || !jsonEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	return allowed == value
+}
+
+// jsonNumber returns the value of a number from the schema (float64) or an upload (json.Number)
+func jsonNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// checkJSONType checks a decoded value against the schema's type keyword
+func checkJSONType(schemaType interface{}, value interface{}, where string) error {
+	var types []string
+	switch t := schemaType.(type) {
+	case nil:
+		return nil
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+
+	actual := jsonTypeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", where, strings.Join(types, " or "), actual)
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,546 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xmlValidator checks that uploads are well-formed XML and, when a schema is
+// configured, that they follow it. Only the commonly used subset of XSD is
+// supported: global and local elements, element refs, named and anonymous
+// complex types with sequence/choice/all, required attributes, simple content,
+// built-in simple types and restrictions with enumerations, patterns and lengths.
+type xmlValidator struct {
+	schema *xsdSchema
+}
+
+func newXMLValidator(cfg ValidatorConfig) (Validator, error) {
+	v := &xmlValidator{}
+	if cfg.Schema == "" {
+		return v, nil
+	}
+
+	data, err := os.ReadFile(cfg.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	schema, err := parseXSD(data)
+	if err != nil {
+		return nil, err
+	}
+	v.schema = schema
+
+	return v, nil
+}
+
+func (v *xmlValidator) Validate(filename string, data []byte) error {
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return invalid("xml", "%v", err)
+	}
+
+	if v.schema == nil {
+		return nil
+	}
+
+	decl, ok := v.schema.elements[root.name]
+	if !ok {
+		return invalid("xml", "root element <%s> is not declared in the schema", root.name)
+	}
+
+	if err := v.schema.validateElement(decl, root, "/"+root.name); err != nil {
+		return invalid("xml", "%v", err)
+	}
+	return nil
+}
+
+// xmlNode is a parsed element of an uploaded document
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xmlNode
+	text     strings.Builder
+}
+
+// parseXMLTree parses a document, failing on anything that is not well-formed
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	// Encodings are normalized to UTF-8 before validation, accept the declaration as is
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var root *xmlNode
+	var stack []*xmlNode
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not well-formed: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, fmt.Errorf("not well-formed: more than one root element")
+				}
+				root = node
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("not well-formed: text outside the root element")
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("not well-formed: no root element")
+	}
+	return root, nil
+}
+
+// Raw XSD structures as they appear in the schema document
+
+type rawSchema struct {
+	Elements     []rawElement     `xml:"element"`
+	ComplexTypes []rawComplexType `xml:"complexType"`
+	SimpleTypes  []rawSimpleType  `xml:"simpleType"`
+}
+
+type rawElement struct {
+	Name        string          `xml:"name,attr"`
+	Type        string          `xml:"type,attr"`
+	Ref         string          `xml:"ref,attr"`
+	MinOccurs   string          `xml:"minOccurs,attr"`
+	MaxOccurs   string          `xml:"maxOccurs,attr"`
+	ComplexType *rawComplexType `xml:"complexType"`
+	SimpleType  *rawSimpleType  `xml:"simpleType"`
+}
+
+type rawGroup struct {
+	Elements []rawElement `xml:"element"`
+}
+
+type rawAttribute struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+	Use  string `xml:"use,attr"`
+}
+
+type rawComplexType struct {
+	Name          string         `xml:"name,attr"`
+	Sequence      *rawGroup      `xml:"sequence"`
+	Choice        *rawGroup      `xml:"choice"`
+	All           *rawGroup      `xml:"all"`
+	Attributes    []rawAttribute `xml:"attribute"`
+	SimpleContent *struct {
+		Extension struct {
+			Base       string         `xml:"base,attr"`
+			Attributes []rawAttribute `xml:"attribute"`
+		} `xml:"extension"`
+	} `xml:"simpleContent"`
+}
+
+type rawFacet struct {
+	Value string `xml:"value,attr"`
+}
+
+type rawSimpleType struct {
+	Name        string `xml:"name,attr"`
+	Restriction *struct {
+		Base         string     `xml:"base,attr"`
+		Enumerations []rawFacet `xml:"enumeration"`
+		Patterns     []rawFacet `xml:"pattern"`
+		MinLength    *rawFacet  `xml:"minLength"`
+		MaxLength    *rawFacet  `xml:"maxLength"`
+	} `xml:"restriction"`
+
+	patterns []*regexp.Regexp // Compiled restriction patterns, see compilePatterns
+}
+
+// Resolved schema model
+
+type xsdSchema struct {
+	elements     map[string]*rawElement
+	complexTypes map[string]*rawComplexType
+	simpleTypes  map[string]*rawSimpleType
+}
+
+func parseXSD(data []byte) (*xsdSchema, error) {
+	var raw rawSchema
+	if err := xml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse XSD: %w", err)
+	}
+
+	schema := &xsdSchema{
+		elements:     make(map[string]*rawElement),
+		complexTypes: make(map[string]*rawComplexType),
+		simpleTypes:  make(map[string]*rawSimpleType),
+	}
+	for i := range raw.Elements {
+		schema.elements[raw.Elements[i].Name] = &raw.Elements[i]
+	}
+	for i := range raw.ComplexTypes {
+		if err := compileComplexType(&raw.ComplexTypes[i], "complex type "+raw.ComplexTypes[i].Name); err != nil {
+			return nil, err
+		}
+		schema.complexTypes[raw.ComplexTypes[i].Name] = &raw.ComplexTypes[i]
+	}
+	for i := range raw.SimpleTypes {
+		if raw.SimpleTypes[i].Restriction == nil {
+			return nil, fmt.Errorf("simple type %s: only restrictions are supported", raw.SimpleTypes[i].Name)
+		}
+		if err := raw.SimpleTypes[i].compilePatterns("simple type " + raw.SimpleTypes[i].Name); err != nil {
+			return nil, err
+		}
+		schema.simpleTypes[raw.SimpleTypes[i].Name] = &raw.SimpleTypes[i]
+	}
+	for i := range raw.Elements {
+		if err := compileElement(&raw.Elements[i], "element "+raw.Elements[i].Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(schema.elements) == 0 {
+		return nil, fmt.Errorf("XSD declares no global elements")
+	}
+	return schema, nil
+}
+
+// compileElement compiles the patterns of the anonymous types declared inside an element
+func compileElement(e *rawElement, where string) error {
+	if e.SimpleType != nil {
+		if err := e.SimpleType.compilePatterns(where); err != nil {
+			return err
+		}
+	}
+	if e.ComplexType != nil {
+		return compileComplexType(e.ComplexType, where)
+	}
+	return nil
+}
+
+// compileComplexType compiles the patterns of the local elements of a complex type
+func compileComplexType(t *rawComplexType, where string) error {
+	for _, group := range []*rawGroup{t.Sequence, t.Choice, t.All} {
+		if group == nil {
+			continue
+		}
+		for i := range group.Elements {
+			if err := compileElement(&group.Elements[i], where+"/"+group.Elements[i].elementName()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compilePatterns compiles the pattern facets once, so that a pattern RE2
+// cannot handle fails when the schema is loaded rather than on an upload
+func (t *rawSimpleType) compilePatterns(where string) error {
+	if t.Restriction == nil {
+		return nil
+	}
+	for _, p := range t.Restriction.Patterns {
+		re, err := regexp.Compile("^(?:" + p.Value + ")$")
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern %s: %w", where, p.Value, err)
+		}
+		t.patterns = append(t.patterns, re)
+	}
+	return nil
+}
+
+// localName strips the namespace prefix of a QName
+func localName(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+// occurs returns the minOccurs/maxOccurs of an element, -1 meaning unbounded
+func occurs(e *rawElement) (int, int) {
+	min, max := 1, 1
+	if e.MinOccurs != "" {
+		min, _ = strconv.Atoi(e.MinOccurs)
+	}
+	if e.MaxOccurs == "unbounded" {
+		max = -1
+	} else if e.MaxOccurs != "" {
+		max, _ = strconv.Atoi(e.MaxOccurs)
+	}
+	return min, max
+}
+
+// resolve follows an element ref to its global declaration
+func (s *xsdSchema) resolve(e *rawElement) (*rawElement, error) {
+	if e.Ref == "" {
+		return e, nil
+	}
+	decl, ok := s.elements[localName(e.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("schema references undeclared element %s", e.Ref)
+	}
+	return decl, nil
+}
+
+func (e *rawElement) elementName() string {
+	if e.Ref != "" {
+		return localName(e.Ref)
+	}
+	return e.Name
+}
+
+func (s *xsdSchema) validateElement(decl *rawElement, node *xmlNode, where string) error {
+	decl, err := s.resolve(decl)
+	if err != nil {
+		return err
+	}
+
+	complexType := decl.ComplexType
+	if complexType == nil && decl.Type != "" {
+		complexType = s.complexTypes[localName(decl.Type)]
+	}
+
+	if complexType == nil {
+		// Simple element, check the text content
+		if len(node.children) > 0 {
+			return fmt.Errorf("%s: element must not have child elements", where)
+		}
+		if decl.SimpleType != nil {
+			return s.checkSimpleType(decl.SimpleType, node.text.String(), where)
+		}
+		return s.checkValue(decl.Type, node.text.String(), where)
+	}
+
+	attributes := append([]rawAttribute(nil), complexType.Attributes...)
+	if complexType.SimpleContent != nil {
+		attributes = append(attributes, complexType.SimpleContent.Extension.Attributes...)
+	}
+	for _, attr := range attributes {
+		value, ok := node.attrs[attr.Name]
+		if !ok {
+			if attr.Use == "required" {
+				return fmt.Errorf("%s: missing required attribute %q", where, attr.Name)
+			}
+			continue
+		}
+		if err := s.checkValue(attr.Type, value, where+"/@"+attr.Name); err != nil {
+			return err
+		}
+	}
+
+	if complexType.SimpleContent != nil {
+		if len(node.children) > 0 {
+			return fmt.Errorf("%s: element must not have child elements", where)
+		}
+		return s.checkValue(complexType.SimpleContent.Extension.Base, node.text.String(), where)
+	}
+
+	switch {
+	case complexType.Sequence != nil:
+		return s.validateSequence(complexType.Sequence.Elements, node.children, where)
+	case complexType.Choice != nil:
+		return s.validateChoice(complexType.Choice.Elements, node.children, where)
+	case complexType.All != nil:
+		return s.validateAll(complexType.All.Elements, node.children, where)
+	}
+
+	if len(node.children) > 0 {
+		return fmt.Errorf("%s: element must be empty", where)
+	}
+	return nil
+}
+
+func (s *xsdSchema) validateSequence(particles []rawElement, children []*xmlNode, where string) error {
+	i := 0
+	for p := range particles {
+		particle := &particles[p]
+		min, max := occurs(particle)
+		name := particle.elementName()
+
+		count := 0
+		for i < len(children) && children[i].name == name && (max < 0 || count < max) {
+			if err := s.validateElement(particle, children[i], where+"/"+name); err != nil {
+				return err
+			}
+			count++
+			i++
+		}
+
+		if count < min {
+			if i < len(children) {
+				return fmt.Errorf("%s: expected <%s>, found <%s>", where, name, children[i].name)
+			}
+			return fmt.Errorf("%s: missing required element <%s>", where, name)
+		}
+	}
+
+	if i < len(children) {
+		return fmt.Errorf("%s: unexpected element <%s>", where, children[i].name)
+	}
+	return nil
+}
+
+func (s *xsdSchema) validateChoice(particles []rawElement, children []*xmlNode, where string) error {
+	if len(children) == 0 {
+		for p := range particles {
+			if min, _ := occurs(&particles[p]); min == 0 {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: missing one of the choice elements", where)
+	}
+
+	for p := range particles {
+		if particles[p].elementName() == children[0].name {
+			return s.validateSequence(particles[p:p+1], children, where)
+		}
+	}
+	return fmt.Errorf("%s: unexpected element <%s>", where, children[0].name)
+}
+
+func (s *xsdSchema) validateAll(particles []rawElement, children []*xmlNode, where string) error {
+	counts := make(map[string]int)
+	for _, child := range children {
+		var particle *rawElement
+		for p := range particles {
+			if particles[p].elementName() == child.name {
+				particle = &particles[p]
+				break
+			}
+		}
+		if particle == nil {
+			return fmt.Errorf("%s: unexpected element <%s>", where, child.name)
+		}
+		if err := s.validateElement(particle, child, where+"/"+child.name); err != nil {
+			return err
+		}
+		counts[child.name]++
+	}
+
+	for p := range particles {
+		min, max := occurs(&particles[p])
+		name := particles[p].elementName()
+		if counts[name] < min {
+			return fmt.Errorf("%s: missing required element <%s>", where, name)
+		}
+		if max >= 0 && counts[name] > max {
+			return fmt.Errorf("%s: element <%s> occurs too many times", where, name)
+		}
+	}
+	return nil
+}
+
+// checkValue checks text against a named simple type, built-in or declared
+func (s *xsdSchema) checkValue(typeName, value, where string) error {
+	if simpleType, ok := s.simpleTypes[localName(typeName)]; ok && typeName != "" {
+		return s.checkSimpleType(simpleType, value, where)
+	}
+
+	value = strings.TrimSpace(value)
+	var err error
+	switch localName(typeName) {
+	case "integer", "int", "long", "short", "byte":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "nonNegativeInteger", "unsignedInt", "unsignedLong", "unsignedShort":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "positiveInteger":
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, 64)
+		if err == nil && n == 0 {
+			err = fmt.Errorf("zero")
+		}
+	case "decimal", "double", "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		switch value {
+		case "true", "false", "1", "0":
+		default:
+			err = fmt.Errorf("not a boolean")
+		}
+	case "date":
+		_, err = time.Parse("2006-01-02", value)
+	case "dateTime":
+		_, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			_, err = time.Parse("2006-01-02T15:04:05", value)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %q is not a valid %s", where, value, localName(typeName))
+	}
+	return nil
+}
+
+func (s *xsdSchema) checkSimpleType(simpleType *rawSimpleType, value, where string) error {
+	restriction := simpleType.Restriction
+	if restriction == nil {
+		return nil
+	}
+
+	if err := s.checkValue(restriction.Base, value, where); err != nil {
+		return err
+	}
+
+	if len(restriction.Enumerations) > 0 {
+		found := false
+		for _, e := range restriction.Enumerations {
+			if e.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %q is not one of the allowed values", where, value)
+		}
+	}
+
+	for i, re := range simpleType.patterns {
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s: %q does not match pattern %s", where, value, restriction.Patterns[i].Value)
+		}
+	}
+
+	length := len([]rune(value))
+	if restriction.MinLength != nil {
+		if min, _ := strconv.Atoi(restriction.MinLength.Value); length < min {
+			return fmt.Errorf("%s: value is shorter than %d characters", where, min)
+		}
+	}
+	if restriction.MaxLength != nil {
+		if max, _ := strconv.Atoi(restriction.MaxLength.Value); length > max {
+			return fmt.Errorf("%s: value is longer than %d characters", where, max)
+		}
+	}
+
+	return nil
+}
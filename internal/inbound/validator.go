@@ -0,0 +1,98 @@
+package inbound
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Validator checks the content of an uploaded file before it is forwarded to the API
+type Validator interface {
+	Validate(filename string, data []byte) error
+}
+
+// ValidatorConfig configures a validator for an inbound folder
+type ValidatorConfig struct {
+	Type       string   `json:"type"`                 // csv, xml, edifact or json
+	Schema     string   `json:"schema,omitempty"`     // Path to the schema file, if the validator uses one
+	Extensions []string `json:"extensions,omitempty"` // Only validate files with these extensions
+}
+
+// ValidatorFactory builds a validator from its configuration
+type ValidatorFactory func(cfg ValidatorConfig) (Validator, error)
+
+var validatorFactories = map[string]ValidatorFactory{
+	"csv":     newCSVValidator,
+	"xml":     newXMLValidator,
+	"edifact": newEDIFACTValidator,
+	"json":    newJSONValidator,
+}
+
+// RegisterValidator makes a validator type available for folder configuration
+func RegisterValidator(name string, factory ValidatorFactory) {
+	validatorFactories[name] = factory
+}
+
+// ValidationError is returned when an uploaded file fails validation
+type ValidationError struct {
+	Validator string
+	Reason    string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed (%s): %s", e.Validator, e.Reason)
+}
+
+// invalid creates a ValidationError with a formatted reason
+func invalid(validator, format string, args ...interface{}) error {
+	return &ValidationError{Validator: validator, Reason: fmt.Sprintf(format, args...)}
+}
+
+// boundValidator applies a validator only to the configured extensions
+type boundValidator struct {
+	validator  Validator
+	extensions []string
+}
+
+// buildValidators creates the validators configured for a folder
+func buildValidators(configs []ValidatorConfig) ([]boundValidator, error) {
+	var validators []boundValidator
+	for _, cfg := range configs {
+		factory, ok := validatorFactories[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown validator type %q", cfg.Type)
+		}
+
+		validator, err := factory(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s validator: %w", cfg.Type, err)
+		}
+
+		var extensions []string
+		for _, ext := range cfg.Extensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			extensions = append(extensions, ext)
+		}
+
+		validators = append(validators, boundValidator{validator: validator, extensions: extensions})
+	}
+	return validators, nil
+}
+
+// Validate runs the folder's validators against an uploaded file
+func (f *Folder) Validate(filename string, data []byte) error {
+	ext := strings.ToLower(path.Ext(filename))
+	for _, v := range f.validators {
+		if len(v.extensions) > 0 && !slices.Contains(v.extensions, ext) {
+			continue
+		}
+		if err := v.validator.Validate(filename, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package inbound
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSchema stores a schema in a temporary file and returns its path
+func writeSchema(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// validatorCase is an upload and the part of the validation error it should give, "" when valid
+type validatorCase struct {
+	name    string
+	data    string
+	wantErr string
+}
+
+func runValidatorCases(t *testing.T, validator Validator, tests []validatorCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate("upload", []byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || !strings.Contains(validationErr.Reason, tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+const orderXSD = `<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="order">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="customer">
+          <xs:simpleType>
+            <xs:restriction base="xs:string">
+              <xs:pattern value="C\d{4}"/>
+            </xs:restriction>
+          </xs:simpleType>
+        </xs:element>
+        <xs:element name="line" type="lineType" maxOccurs="unbounded"/>
+      </xs:sequence>
+      <xs:attribute name="id" type="xs:positiveInteger" use="required"/>
+    </xs:complexType>
+  </xs:element>
+  <xs:complexType name="lineType">
+    <xs:all>
+      <xs:element name="product" type="xs:string"/>
+      <xs:element name="quantity" type="xs:decimal"/>
+      <xs:element name="unit" type="unitType" minOccurs="0"/>
+    </xs:all>
+  </xs:complexType>
+  <xs:simpleType name="unitType">
+    <xs:restriction base="xs:string">
+      <xs:enumeration value="kpl"/>
+      <xs:enumeration value="m"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`
+
+func TestXMLValidator(t *testing.T) {
+	validator, err := newXMLValidator(ValidatorConfig{Schema: writeSchema(t, "order.xsd", orderXSD)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := "<line><quantity>2.5</quantity><product>1001</product><unit>m</unit></line>"
+	runValidatorCases(t, validator, []validatorCase{
+		{"valid", `<order id="7"><customer>C1234</customer>` + line + line + `</order>`, ""},
+		{"not well-formed", `<order id="7"><customer>C1234</order>`, "not well-formed"},
+		{"undeclared root", `<invoice/>`, "root element <invoice> is not declared"},
+		{"missing attribute", `<order><customer>C1234</customer>` + line + `</order>`, `missing required attribute "id"`},
+		{"invalid attribute", `<order id="0"><customer>C1234</customer>` + line + `</order>`, "not a valid positiveInteger"},
+		{"inline pattern", `<order id="7"><customer>C12345</customer>` + line + `</order>`, "does not match pattern"},
+		{"missing element", `<order id="7"><customer>C1234</customer></order>`, "missing required element <line>"},
+		{"wrong order", `<order id="7">` + line + `<customer>C1234</customer></order>`, "expected <customer>, found <line>"},
+		{"enumeration", `<order id="7"><customer>C1234</customer><line><product>1</product><quantity>1</quantity><unit>kg</unit></line></order>`, "not one of the allowed values"},
+		{"invalid decimal", `<order id="7"><customer>C1234</customer><line><product>1</product><quantity>x</quantity></line></order>`, "not a valid decimal"},
+	})
+}
+
+func TestParseXSDPatterns(t *testing.T) {
+	// Patterns are anchored like XSD patterns, and ones RE2 cannot compile fail at load time
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{"plain", `\d{4}`, false},
+		{"backreference", `(\d)\1`, true},
+		{"lookahead", `(?=C)\w+`, true},
+	}
+	for _, tt := range tests {
+		for _, where := range []string{"named", "inline"} {
+			schema := `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="code" type="codeType"/>
+  <xs:simpleType name="codeType"><xs:restriction base="xs:string"><xs:pattern value="` + tt.pattern + `"/></xs:restriction></xs:simpleType>
+</xs:schema>`
+			if where == "inline" {
+				schema = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="order"><xs:complexType><xs:sequence>
+    <xs:element name="code"><xs:simpleType><xs:restriction base="xs:string"><xs:pattern value="` + tt.pattern + `"/></xs:restriction></xs:simpleType></xs:element>
+  </xs:sequence></xs:complexType></xs:element>
+</xs:schema>`
+			}
+			_, err := parseXSD([]byte(schema))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("%s %s pattern: parseXSD error = %v, wantErr %v", tt.name, where, err, tt.wantErr)
+			}
+		}
+	}
+
+	schema, err := parseXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="code"><xs:simpleType><xs:restriction base="xs:string"><xs:pattern value="\d{4}"/></xs:restriction></xs:simpleType></xs:element>
+</xs:schema>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := &xmlValidator{schema: schema}
+	runValidatorCases(t, validator, []validatorCase{
+		{"whole value", "<code>1234</code>", ""},
+		{"anchored", "<code>12345</code>", "does not match pattern"},
+	})
+
+	if _, err := parseXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"/>`)); err == nil {
+		t.Error("schema without elements accepted")
+	}
+}
+
+const orderJSONSchema = `{
+  "type": "object",
+  "required": ["customer", "lines"],
+  "additionalProperties": false,
+  "properties": {
+    "customer": {"type": "string", "pattern": "^C\\d{4}$"},
+    "priority": {"enum": [1, 2, "urgent"]},
+    "lines": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/line"}}
+  },
+  "definitions": {
+    "line": {
+      "type": "object",
+      "required": ["product", "quantity"],
+      "properties": {
+        "product": {"type": "string", "minLength": 1, "maxLength": 10},
+        "quantity": {"type": "number", "minimum": 0},
+        "note": {"type": ["string", "null"]}
+      }
+    }
+  }
+}`
+
+func TestJSONValidator(t *testing.T) {
+	validator, err := newJSONValidator(ValidatorConfig{Schema: writeSchema(t, "order.json", orderJSONSchema)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runValidatorCases(t, validator, []validatorCase{
+		{"valid", `{"customer": "C1234", "priority": 2, "lines": [{"product": "1001", "quantity": 2.5, "note": null}]}`, ""},
+		{"enum number by value", `{"customer": "C1234", "priority": 1.0, "lines": [{"product": "1001", "quantity": 1}]}`, ""},
+		{"enum string", `{"customer": "C1234", "priority": "urgent", "lines": [{"product": "1001", "quantity": 1}]}`, ""},
+		{"enum type differs", `{"customer": "C1234", "priority": "1", "lines": [{"product": "1001", "quantity": 1}]}`, "not one of the allowed values"},
+		{"trailing data", `{"customer": "C1234", "lines": [{"product": "1001", "quantity": 1}]} {}`, "unexpected data after the top-level value"},
+		{"not JSON", `{"customer": `, "not valid JSON"},
+		{"missing property", `{"customer": "C1234"}`, `missing required property "lines"`},
+		{"additional property", `{"customer": "C1234", "lines": [{"product": "1", "quantity": 1}], "x": 1}`, `unexpected property "x"`},
+		{"pattern", `{"customer": "C12", "lines": [{"product": "1", "quantity": 1}]}`, "does not match pattern"},
+		{"min items", `{"customer": "C1234", "lines": []}`, "fewer than 1 items"},
+		{"ref type", `{"customer": "C1234", "lines": [{"product": 1001, "quantity": 1}]}`, "$.lines[0].product: expected string, got integer"},
+		{"max length", `{"customer": "C1234", "lines": [{"product": "12345678901", "quantity": 1}]}`, "longer than 10 characters"},
+		{"minimum", `{"customer": "C1234", "lines": [{"product": "1", "quantity": -1}]}`, "less than the minimum"},
+		{"type list", `{"customer": "C1234", "lines": [{"product": "1", "quantity": 1, "note": 5}]}`, "expected string or null"},
+	})
+
+	schemaless, _ := newJSONValidator(ValidatorConfig{})
+	runValidatorCases(t, schemaless, []validatorCase{
+		{"any JSON", `[1, "two"]`, ""},
+		{"trailing data without schema", `[1] [2]`, "unexpected data"},
+	})
+}
+
+func TestJSONSchemaRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"recursive through properties", `{"$defs": {"node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/node"}}}}, "$ref": "#/$defs/node"}`, ""},
+		{"cycle", `{"definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"$ref": "#/definitions/a"}}, "$ref": "#/definitions/a"}`, "circular $ref"},
+		{"self", `{"$ref": "#"}`, "circular $ref"},
+		{"unknown", `{"properties": {"x": {"$ref": "#/definitions/missing"}}}`, "unknown $ref"},
+		{"remote", `{"items": {"$ref": "https://example.com/line.json"}}`, "unsupported or unknown $ref"},
+		{"invalid pattern", `{"properties": {"x": {"pattern": "(?=a)"}}}`, "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newJSONValidator(ValidatorConfig{Schema: writeSchema(t, "schema.json", tt.schema)})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// edifactOrder builds an interchange with one ORDERS message whose UNT counts untCount segments
+func edifactOrder(untCount string) string {
+	return "UNB+UNOC:3+SENDER+RECIPIENT+260304:1020+REF1'\n" +
+		"UNH+M1+ORDERS:D:96A:UN'\n" +
+		"BGM+220+PO?+1001+9'\n" +
+		"DTM+137:20260304:102'\n" +
+		"LIN+1++1001:SA'\n" +
+		"UNT+" + untCount + "+M1'\n" +
+		"UNZ+1+REF1'\n"
+}
+
+func TestEDIFACTValidator(t *testing.T) {
+	validator, _ := newEDIFACTValidator(ValidatorConfig{})
+	valid := edifactOrder("5")
+
+	runValidatorCases(t, validator, []validatorCase{
+		{"valid", valid, ""},
+		{"service string advice", "UNA:+.? '" + valid, ""},
+		{"other separators", "UNA|*.# !" + strings.NewReplacer("?+", "#*", "+", "*", ":", "|", "'", "!").Replace(valid), ""},
+		{"empty", "  \n", "no segments"},
+		{"no UNB", strings.TrimPrefix(valid, "UNB+UNOC:3+SENDER+RECIPIENT+260304:1020+REF1'\n"), "must start with UNB"},
+		{"no UNZ", strings.TrimSuffix(valid, "UNZ+1+REF1'\n"), "must end with UNZ"},
+		{"interchange reference", strings.Replace(valid, "UNZ+1+REF1", "UNZ+1+REF2", 1), "does not match UNB reference"},
+		{"message count", strings.Replace(valid, "UNZ+1+", "UNZ+2+", 1), "UNZ message count"},
+		{"segment count", edifactOrder("4"), "UNT segment count"},
+		{"message reference", strings.Replace(valid, "UNT+5+M1", "UNT+5+M2", 1), "does not match UNH reference"},
+		{"message type", strings.Replace(valid, "ORDERS:D", "INVOIC:D", 1), "is not ORDERS"},
+		{"missing UNT", strings.Replace(valid, "UNT+5+M1'\n", "", 1), "missing UNT"},
+		{"BGM first", strings.Replace(valid, "BGM+220+PO?+1001+9'\nDTM+137:20260304:102'", "DTM+137:20260304:102'\nBGM+220+PO?+1001+9'", 1), "BGM must follow UNH"},
+		{"no LIN", strings.Replace(strings.Replace(valid, "LIN+1++1001:SA'\n", "", 1), "UNT+5", "UNT+4", 1), "no LIN segments"},
+		{"unterminated", strings.TrimSuffix(valid, "'\n"), "not terminated"},
+	})
+}
+
+func TestCSVValidator(t *testing.T) {
+	schema := writeSchema(t, "order.json", `{
+  "header": true,
+  "min_rows": 1,
+  "columns": [
+    {"name": "tuote", "type": "string", "required": true, "pattern": "^\\d{4}$"},
+    {"name": "maara", "type": "decimal", "required": true},
+    {"name": "toimitus", "type": "date"},
+    {"name": "rivi", "type": "integer"}
+  ]
+}`)
+	validator, err := newCSVValidator(ValidatorConfig{Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runValidatorCases(t, validator, []validatorCase{
+		{"valid", "tuote;maara;toimitus;rivi\n1001;2,5;2026-03-04;1\n1002;1;4.3.2026;\n\n", ""},
+		{"columns by header name", "MAARA;Tuote\n3;1001\n", ""},
+		{"empty", "", "file is empty"},
+		{"missing column", "maara\n1\n", `missing required column "tuote"`},
+		{"no rows", "tuote;maara\n", "expected at least 1 data rows"},
+		{"required value", "tuote;maara\n1001;\n", `line 2: column "maara" is required`},
+		{"decimal", "tuote;maara\n1001;kaksi\n", "not a decimal number"},
+		{"date", "tuote;maara;toimitus\n1001;1;04/03/2026\n", "not a date"},
+		{"integer", "tuote;maara;rivi\n1001;1;1.5\n", "not an integer"},
+		{"pattern", "tuote;maara\n10011;1\n", "does not match pattern"},
+		{"broken quoting", "tuote;maara\n\"1001;1\n", "line"},
+	})
+
+	for _, invalid := range []string{
+		`{"columns": [{"name": "x", "type": "money"}]}`,
+		`{"columns": [{"name": "x", "pattern": "(?=a)"}]}`,
+		`{"columns": `,
+	} {
+		if _, err := newCSVValidator(ValidatorConfig{Schema: writeSchema(t, "schema.json", invalid)}); err == nil {
+			t.Errorf("schema accepted: %s", invalid)
+		}
+	}
+	if _, err := newCSVValidator(ValidatorConfig{}); err == nil {
+		t.Error("csv validator created without a schema")
+	}
+}
@@ -0,0 +1,191 @@
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Download encryption modes for a user's pricelists
+const (
+	DownloadsOff         = "off"         // Serve pricelists unencrypted only
+	DownloadsSuffix      = "pgp"         // Offer an encrypted .pgp copy next to each pricelist (default)
+	DownloadsTransparent = "transparent" // Serve pricelists encrypted under their normal names
+)
+
+// EncryptedSuffix is the name suffix of encrypted downloads
+const EncryptedSuffix = ".pgp"
+
+// Settings are a user's OpenPGP settings
+type Settings struct {
+	PublicKeyFile string `json:"public_key_file"`     // The customer's public key, armored or binary
+	Downloads     string `json:"downloads,omitempty"` // off, pgp or transparent
+
+	publicKey openpgp.EntityList
+}
+
+// Load validates the settings and reads the customer's public key
+func (s *Settings) Load() error {
+	switch s.Downloads {
+	case "":
+		s.Downloads = DownloadsSuffix
+	case DownloadsOff, DownloadsSuffix, DownloadsTransparent:
+	default:
+		return fmt.Errorf("unknown PGP download mode %q", s.Downloads)
+	}
+
+	if s.PublicKeyFile == "" {
+		return fmt.Errorf("no PGP public key file")
+	}
+	keys, err := readKeyFile(s.PublicKeyFile)
+	if err != nil {
+		return err
+	}
+	s.publicKey = keys
+	return nil
+}
+
+// PublicKey returns the customer's public key
+func (s *Settings) PublicKey() openpgp.EntityList {
+	return s.publicKey
+}
+
+// LoadPrivateKey reads the service's private key, decrypting it with passphrase if it is protected
+func LoadPrivateKey(filename, passphrase string) (openpgp.EntityList, error) {
+	keys, err := readKeyFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entity := range keys {
+		if entity.PrivateKey == nil {
+			return nil, fmt.Errorf("%s does not contain a private key", filename)
+		}
+		if entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to unlock PGP private key: %w", err)
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+					return nil, fmt.Errorf("failed to unlock PGP private subkey: %w", err)
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// readKeyFile reads an armored or binary key ring
+func readKeyFile(filename string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PGP key: %w", err)
+	}
+
+	var keys openpgp.EntityList
+	if isArmored(data) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PGP key %s: %w", filename, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PGP keys in %s", filename)
+	}
+	return keys, nil
+}
+
+// IsEncryptedName reports whether an upload name marks an OpenPGP message
+func IsEncryptedName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".pgp") || strings.HasSuffix(lower, ".asc") || strings.HasSuffix(lower, ".gpg")
+}
+
+// TrimEncryptedName returns the name of the decrypted file, "order.csv" for "order.csv.pgp"
+func TrimEncryptedName(name string) string {
+	if IsEncryptedName(name) {
+		return name[:len(name)-4]
+	}
+	return name
+}
+
+// Decrypt decrypts an armored or binary OpenPGP message with the service key and
+// checks that it is signed by the customer's key
+func Decrypt(data []byte, serviceKey, customerKey openpgp.EntityList) ([]byte, error) {
+	var in io.Reader = bytes.NewReader(data)
+	if isArmored(data) {
+		block, err := armor.Decode(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PGP armor: %w", err)
+		}
+		in = block.Body
+	}
+
+	keyring := append(append(openpgp.EntityList{}, serviceKey...), customerKey...)
+	md, err := openpgp.ReadMessage(in, keyring, nil, nil)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("PGP message is truncated")
+		}
+		return nil, fmt.Errorf("failed to decrypt PGP message: %w", err)
+	}
+	if !md.IsEncrypted {
+		return nil, fmt.Errorf("PGP message is not encrypted")
+	}
+
+	// The signature can only be checked once the whole body has been read
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt PGP message: %w", err)
+	}
+
+	switch {
+	case !md.IsSigned:
+		return nil, fmt.Errorf("PGP message is not signed")
+	case md.SignedBy == nil:
+		return nil, fmt.Errorf("PGP message is signed with an unknown key %X", md.SignedByKeyId)
+	case md.SignatureError != nil:
+		return nil, fmt.Errorf("PGP signature is not valid: %w", md.SignatureError)
+	}
+	if customerKey.KeysById(md.SignedByKeyId) == nil {
+		return nil, fmt.Errorf("PGP message is not signed by the customer's key")
+	}
+
+	return plain, nil
+}
+
+// Encrypt encrypts data to the customer's key, signing it with the service key when one is given
+func Encrypt(data []byte, name string, customerKey, serviceKey openpgp.EntityList) ([]byte, error) {
+	var signer *openpgp.Entity
+	if len(serviceKey) > 0 {
+		signer = serviceKey[0]
+	}
+
+	var out bytes.Buffer
+	plaintext, err := openpgp.Encrypt(&out, customerKey, signer, &openpgp.FileHints{IsBinary: true, FileName: name}, &packet.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if _, err := plaintext.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if err := plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
@@ -0,0 +1,149 @@
+package pgp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+func newEntity(t *testing.T, name string) openpgp.EntityList {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	return openpgp.EntityList{entity}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	service, customer, stranger := newEntity(t, "service"), newEntity(t, "customer"), newEntity(t, "stranger")
+	plain := []byte("product;price\n1001;12.50\n")
+
+	// Uploads are encrypted to the service and signed by the customer
+	signed, err := Encrypt(plain, "order.csv", service, customer)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	unsigned, err := Encrypt(plain, "order.csv", service, nil)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	foreign, err := Encrypt(plain, "order.csv", service, stranger)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, "PGP MESSAGE", nil)
+	if err != nil {
+		t.Fatalf("armor.Encode: %v", err)
+	}
+	w.Write(signed)
+	w.Close()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"binary", signed, ""},
+		{"armored", armored.Bytes(), ""},
+		{"unsigned", unsigned, "not signed"},
+		{"unknown signer", foreign, "unknown key"},
+		{"truncated", signed[:len(signed)/2], "PGP"},
+		{"not pgp", []byte("product;price\n"), "failed to decrypt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decrypt(tt.data, service, customer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decrypt error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("Decrypt = %q, want %q", got, plain)
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsOtherRecipient(t *testing.T) {
+	service, customer := newEntity(t, "service"), newEntity(t, "customer")
+	data, err := Encrypt([]byte("x"), "x", newEntity(t, "other"), customer)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := Decrypt(data, service, customer); err == nil {
+		t.Fatal("Decrypt succeeded for a message to another key")
+	}
+}
+
+func TestSettingsLoad(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "customer.asc")
+	var buf bytes.Buffer
+	w, _ := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err := newEntity(t, "customer")[0].Serialize(w); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	w.Close()
+	if err := os.WriteFile(keyFile, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		settings Settings
+		want     string
+		wantErr  bool
+	}{
+		{"default mode", Settings{PublicKeyFile: keyFile}, DownloadsSuffix, false},
+		{"transparent", Settings{PublicKeyFile: keyFile, Downloads: DownloadsTransparent}, DownloadsTransparent, false},
+		{"unknown mode", Settings{PublicKeyFile: keyFile, Downloads: "always"}, "", true},
+		{"no key", Settings{}, "", true},
+		{"missing key file", Settings{PublicKeyFile: filepath.Join(dir, "missing.asc")}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (tt.settings.Downloads != tt.want || len(tt.settings.PublicKey()) != 1) {
+				t.Errorf("Load = %q with %d keys", tt.settings.Downloads, len(tt.settings.PublicKey()))
+			}
+		})
+	}
+}
+
+func TestEncryptedNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		encrypted bool
+		trimmed   string
+	}{
+		{"order.csv.pgp", true, "order.csv"},
+		{"order.csv.GPG", true, "order.csv"},
+		{"order.xml.asc", true, "order.xml"},
+		{"order.csv", false, "order.csv"},
+		{"pgp", false, "pgp"},
+	}
+	for _, tt := range tests {
+		if got := IsEncryptedName(tt.name); got != tt.encrypted {
+			t.Errorf("IsEncryptedName(%q) = %v", tt.name, got)
+		}
+		if got := TrimEncryptedName(tt.name); got != tt.trimmed {
+			t.Errorf("TrimEncryptedName(%q) = %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package pricelist
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ArchiveName is the full pricelist archive served from /Hinnat
+const ArchiveName = "salhydro_kaikki.zip"
+
+// FetchFunc downloads a user's current pricelist archive
+type FetchFunc func(username, apiKey string) ([]byte, error)
+
+// Archive is a downloaded pricelist archive
+type Archive struct {
+	ID      string // Content hash, see VersionID
+	Data    []byte
+	Fetched time.Time
+
+	once   sync.Once
+	reader *zip.Reader
+	err    error
+
+	convMu    sync.Mutex
+	converted map[string][]byte      // Derived files by name, see Formats.Convert
+	deltas    map[string]cachedDelta // Deltas by base version ID, see Delta
+}
+
+type cachedDelta struct {
+	data    []byte
+	summary DeltaSummary
+}
+
+// zipReader parses the archive the first time its entries are needed
+func (a *Archive) zipReader() (*zip.Reader, error) {
+	a.once.Do(func() {
+		a.reader, a.err = zip.NewReader(bytes.NewReader(a.Data), int64(len(a.Data)))
+		if a.err != nil {
+			a.err = fmt.Errorf("pricelist archive is not a valid zip: %w", a.err)
+		}
+	})
+	return a.reader, a.err
+}
+
+type cacheEntry struct {
+	mu      sync.Mutex // Held while the archive is fetched so concurrent readers share one download
+	archive *Archive
+}
+
+// Cache keeps each user's pricelist archive for a while so that listing and
+// reading entries does not download the whole archive every time
+type Cache struct {
+	ttl    time.Duration
+	fetch  FetchFunc
+	now    func() time.Time
+	logger *log.Logger // Standard logger when nil
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// NewCache creates a cache. A zero ttl fetches the archive on every Get.
+// now is the clock archives are timed with, nil for time.Now.
+func NewCache(ttl time.Duration, fetch FetchFunc, now func() time.Time) *Cache {
+	if now == nil {
+		now = time.Now
+	}
+	return &Cache{
+		ttl:     ttl,
+		fetch:   fetch,
+		now:     now,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// SetLogger sends the cache's log output to logger instead of the standard logger
+func (c *Cache) SetLogger(logger *log.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logger = logger
+}
+
+// logTo logs through logger, or the standard logger when it is nil
+func logTo(logger *log.Logger, format string, args ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (c *Cache) entry(username string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[username]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[username] = entry
+	}
+	return entry
+}
+
+// Get returns the user's archive, downloading it when the cached copy is missing or stale
+func (c *Cache) Get(username, apiKey string) (*Archive, error) {
+	entry := c.entry(username)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.archive != nil && c.now().Sub(entry.archive.Fetched) < c.ttl {
+		return entry.archive, nil
+	}
+
+	data, err := c.fetch(username, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.archive = &Archive{ID: VersionID(data), Data: data, Fetched: c.now()}
+	logTo(c.logger, "Cached pricelist archive for %s (%d bytes)", username, len(data))
+	return entry.archive, nil
+}
+
+// Peek returns the user's cached archive without downloading it
+func (c *Cache) Peek(username string) (*Archive, bool) {
+	entry := c.entry(username)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.archive == nil || c.now().Sub(entry.archive.Fetched) >= c.ttl {
+		return nil, false
+	}
+	return entry.archive, true
+}
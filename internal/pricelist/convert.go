@@ -0,0 +1,309 @@
+package pricelist
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Table is the tabular content of a pricelist, combined from the CSV files in the archive
+type Table struct {
+	Columns []Column
+	Rows    [][]string
+}
+
+// Column is an output column of a converted pricelist
+type Column struct {
+	Name  string
+	Width int // Fixed-width column size, 0 fits the longest value
+}
+
+// Converter turns the pricelist table into a file format
+type Converter interface {
+	Convert(table *Table) ([]byte, error)
+}
+
+// ConverterConfig configures one derived pricelist file
+type ConverterConfig struct {
+	Type      string `json:"type"`                // csv, json, xlsx or fixed
+	Extension string `json:"extension,omitempty"` // File name extension, defaults per type
+	Delimiter string `json:"delimiter,omitempty"` // csv only, defaults to ;
+}
+
+// ConverterFactory builds a converter from its configuration
+type ConverterFactory func(cfg ConverterConfig) (Converter, error)
+
+var converterFactories = map[string]ConverterFactory{
+	"csv":   newCSVConverter,
+	"json":  newJSONConverter,
+	"xlsx":  newXLSXConverter,
+	"fixed": newFixedConverter,
+}
+
+// defaultExtensions are the file name extensions of the built-in converter types
+var defaultExtensions = map[string]string{
+	"fixed": "txt",
+}
+
+// RegisterConverter makes a converter type available for pricelist formats
+func RegisterConverter(name string, factory ConverterFactory) {
+	converterFactories[name] = factory
+}
+
+// ColumnMapping selects a source column and names it in the output
+type ColumnMapping struct {
+	Source string `json:"source"`          // Column name in the pricelist, matched case-insensitively
+	Name   string `json:"name,omitempty"`  // Output column name, defaults to the source name
+	Width  int    `json:"width,omitempty"` // Column size in fixed-width output
+}
+
+// Formats configures the derived pricelist files offered to a user
+type Formats struct {
+	Formats []ConverterConfig `json:"formats"`
+	Columns []ColumnMapping   `json:"columns,omitempty"` // Empty keeps all columns as they are
+
+	converters map[string]Converter // By file name extension
+	names      []string
+}
+
+// Load validates the settings and builds the converters
+func (f *Formats) Load() error {
+	f.converters = make(map[string]Converter)
+	f.names = nil
+
+	for _, cfg := range f.Formats {
+		factory, ok := converterFactories[cfg.Type]
+		if !ok {
+			return fmt.Errorf("unknown pricelist format %q", cfg.Type)
+		}
+
+		ext := strings.TrimPrefix(strings.ToLower(cfg.Extension), ".")
+		if ext == "" {
+			ext = cfg.Type
+			if def, ok := defaultExtensions[cfg.Type]; ok {
+				ext = def
+			}
+		}
+		if ext == "zip" || strings.ContainsAny(ext, "/.") {
+			return fmt.Errorf("invalid pricelist format extension %q", ext)
+		}
+		if _, dup := f.converters[ext]; dup {
+			return fmt.Errorf("pricelist format extension %q is used twice", ext)
+		}
+
+		converter, err := factory(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to create %s pricelist format: %w", cfg.Type, err)
+		}
+		f.converters[ext] = converter
+		f.names = append(f.names, strings.TrimSuffix(ArchiveName, ".zip")+"."+ext)
+	}
+
+	for _, column := range f.Columns {
+		if column.Source == "" {
+			return fmt.Errorf("pricelist column mapping without a source column")
+		}
+		if column.Width < 0 {
+			return fmt.Errorf("pricelist column %q has a negative width", column.Source)
+		}
+	}
+	return nil
+}
+
+// Names returns the file names of the derived pricelist files
+func (f *Formats) Names() []string {
+	if f == nil {
+		return nil
+	}
+	return f.names
+}
+
+// Convert builds the derived file name from the archive, reusing an earlier conversion of the same archive
+func (f *Formats) Convert(archive *Archive, name string) ([]byte, error) {
+	if f == nil || path.Dir(name) != "." {
+		return nil, errNotConverted
+	}
+	base, ext, _ := strings.Cut(name, ".")
+	converter, ok := f.converters[ext]
+	if !ok || base+".zip" != ArchiveName {
+		return nil, errNotConverted
+	}
+
+	archive.convMu.Lock()
+	defer archive.convMu.Unlock()
+	if data, ok := archive.converted[name]; ok {
+		return data, nil
+	}
+
+	table, err := archive.Table()
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := table.Map(f.Columns)
+	if err != nil {
+		return nil, err
+	}
+	data, err := converter.Convert(mapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert pricelist to %s: %w", ext, err)
+	}
+
+	if archive.converted == nil {
+		archive.converted = make(map[string][]byte)
+	}
+	archive.converted[name] = data
+	return data, nil
+}
+
+// ConvertedSize returns the size of a derived file converted earlier from the archive
+func (a *Archive) ConvertedSize(name string) (int64, bool) {
+	a.convMu.Lock()
+	defer a.convMu.Unlock()
+	data, ok := a.converted[name]
+	return int64(len(data)), ok
+}
+
+// IsConverted reports whether name is one of the derived pricelist files
+func (f *Formats) IsConverted(name string) bool {
+	for _, n := range f.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var errNotConverted = fmt.Errorf("not a converted pricelist file: %w", os.ErrNotExist)
+
+// sortedNames returns the file names of an archive in order
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Table reads the CSV files of the archive into one table. Files with
+// different headers are combined into the union of their columns.
+func (a *Archive) Table() (*Table, error) {
+	files, err := archiveFiles(a)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &Table{}
+	index := make(map[string]int)
+	for _, name := range sortedNames(files) {
+		if !strings.EqualFold(path.Ext(name), ".csv") {
+			continue
+		}
+
+		records, err := readCSV(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		positions := make([]int, len(records[0]))
+		for i, header := range records[0] {
+			header = strings.TrimSpace(header)
+			pos, ok := index[strings.ToLower(header)]
+			if !ok {
+				pos = len(table.Columns)
+				index[strings.ToLower(header)] = pos
+				table.Columns = append(table.Columns, Column{Name: header})
+			}
+			positions[i] = pos
+		}
+
+		for _, record := range records[1:] {
+			row := make([]string, len(table.Columns))
+			for i, value := range record {
+				if i < len(positions) {
+					row[positions[i]] = value
+				}
+			}
+			table.Rows = append(table.Rows, row)
+		}
+	}
+
+	if len(table.Columns) == 0 {
+		return nil, fmt.Errorf("pricelist archive has no CSV files")
+	}
+	for i, row := range table.Rows {
+		if len(row) < len(table.Columns) {
+			table.Rows[i] = append(row, make([]string, len(table.Columns)-len(row))...)
+		}
+	}
+	return table, nil
+}
+
+// Map selects and renames columns. No mappings return the table unchanged.
+func (t *Table) Map(mappings []ColumnMapping) (*Table, error) {
+	if len(mappings) == 0 {
+		return t, nil
+	}
+
+	mapped := &Table{}
+	var sources []int
+	for _, mapping := range mappings {
+		source := -1
+		for i, column := range t.Columns {
+			if strings.EqualFold(column.Name, mapping.Source) {
+				source = i
+				break
+			}
+		}
+		if source < 0 {
+			return nil, fmt.Errorf("pricelist has no column %q", mapping.Source)
+		}
+
+		name := mapping.Name
+		if name == "" {
+			name = t.Columns[source].Name
+		}
+		mapped.Columns = append(mapped.Columns, Column{Name: name, Width: mapping.Width})
+		sources = append(sources, source)
+	}
+
+	for _, row := range t.Rows {
+		out := make([]string, len(sources))
+		for i, source := range sources {
+			out[i] = row[source]
+		}
+		mapped.Rows = append(mapped.Rows, out)
+	}
+	return mapped, nil
+}
+
+// readCSV parses a pricelist CSV file, decoding it from Windows-1252 when it is not UTF-8
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	if !utf8.Valid(data) {
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+
+	header, _, _ := strings.Cut(string(data), "\n")
+	sep, _ := utf8.DecodeRuneInString(detectSeparator(header))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = sep
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return reader.ReadAll()
+}
@@ -0,0 +1,45 @@
+package pricelist
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"unicode/utf8"
+)
+
+type csvConverter struct {
+	delimiter rune
+}
+
+func newCSVConverter(cfg ConverterConfig) (Converter, error) {
+	delimiter := ';'
+	if cfg.Delimiter != "" {
+		if cfg.Delimiter == `\t` {
+			cfg.Delimiter = "\t"
+		}
+		r, size := utf8.DecodeRuneInString(cfg.Delimiter)
+		if size != len(cfg.Delimiter) || r == '"' || r == '\n' || r == '\r' {
+			return nil, fmt.Errorf("invalid csv delimiter %q", cfg.Delimiter)
+		}
+		delimiter = r
+	}
+	return &csvConverter{delimiter: delimiter}, nil
+}
+
+func (c *csvConverter) Convert(table *Table) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = c.delimiter
+
+	header := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		header[i] = column.Name
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	if err := w.WriteAll(table.Rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package pricelist
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// fixedConverter writes each row as one line of padded columns without a header.
+// Columns without a configured width fit their longest value; longer values are cut.
+type fixedConverter struct{}
+
+func newFixedConverter(cfg ConverterConfig) (Converter, error) {
+	return fixedConverter{}, nil
+}
+
+func (fixedConverter) Convert(table *Table) ([]byte, error) {
+	widths := make([]int, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = column.Width
+		if widths[i] > 0 {
+			continue
+		}
+		for _, row := range table.Rows {
+			if n := utf8.RuneCountInString(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, row := range table.Rows {
+		for i, value := range row {
+			runes := []rune(value)
+			if len(runes) > widths[i] {
+				runes = runes[:widths[i]]
+			}
+			b.WriteString(string(runes))
+			b.WriteString(strings.Repeat(" ", widths[i]-len(runes)))
+		}
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,48 @@
+package pricelist
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonConverter writes the rows as an array of objects, keeping the column order
+type jsonConverter struct{}
+
+func newJSONConverter(cfg ConverterConfig) (Converter, error) {
+	return jsonConverter{}, nil
+}
+
+func (jsonConverter) Convert(table *Table) ([]byte, error) {
+	keys := make([][]byte, len(table.Columns))
+	for i, column := range table.Columns {
+		key, err := json.Marshal(column.Name)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for r, row := range table.Rows {
+		if r > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for i, value := range row {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(keys[i])
+			buf.WriteString(": ")
+			buf.Write(encoded)
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("\n]\n")
+	return buf.Bytes(), nil
+}
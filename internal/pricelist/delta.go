@@ -0,0 +1,236 @@
+package pricelist
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeltaName is the generated archive with the changes since the user's last download
+const DeltaName = "salhydro_muutokset.zip"
+
+// deltaInfoName is the summary file inside the delta archive
+const deltaInfoName = "muutokset.txt"
+
+// Row change markers written in the first column of delta CSV files
+const (
+	ChangeAdded   = "added"
+	ChangeChanged = "changed"
+	ChangeRemoved = "removed"
+)
+
+// DeltaSummary counts the changes in a delta archive
+type DeltaSummary struct {
+	Added   int
+	Changed int
+	Removed int
+	Files   int // Non-CSV files included because their content changed
+}
+
+// BuildDelta compares two archive versions and returns a zip holding only what
+// changed. CSV files are compared row by row, keyed by their first column, and
+// each delta row is prefixed with added, changed or removed. Other files are
+// included whole when their content differs.
+func BuildDelta(base, target *Archive, at time.Time) ([]byte, DeltaSummary, error) {
+	var summary DeltaSummary
+
+	baseFiles, err := archiveFiles(base)
+	if err != nil {
+		return nil, summary, err
+	}
+	targetFiles, err := archiveFiles(target)
+	if err != nil {
+		return nil, summary, err
+	}
+
+	names := make(map[string]bool)
+	for name := range baseFiles {
+		names[name] = true
+	}
+	for name := range targetFiles {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: at})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	for _, name := range sorted {
+		oldData, newData := baseFiles[name], targetFiles[name]
+		if strings.EqualFold(path.Ext(name), ".csv") {
+			delta, added, changed, removed := diffCSV(oldData, newData)
+			if added+changed+removed == 0 {
+				continue
+			}
+			summary.Added += added
+			summary.Changed += changed
+			summary.Removed += removed
+			if err := write(name, delta); err != nil {
+				return nil, summary, fmt.Errorf("failed to write delta archive: %w", err)
+			}
+			continue
+		}
+
+		if newData != nil && !bytes.Equal(oldData, newData) {
+			summary.Files++
+			if err := write(name, newData); err != nil {
+				return nil, summary, fmt.Errorf("failed to write delta archive: %w", err)
+			}
+		}
+	}
+
+	var info strings.Builder
+	fmt.Fprintf(&info, "Changes since pricelist version %s (%s)\n", base.ID, base.Fetched.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&info, "Current version: %s (%s)\n\n", target.ID, target.Fetched.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&info, "Rows added:   %d\nRows changed: %d\nRows removed: %d\nOther files:  %d\n",
+		summary.Added, summary.Changed, summary.Removed, summary.Files)
+	if err := write(deltaInfoName, []byte(info.String())); err != nil {
+		return nil, summary, fmt.Errorf("failed to write delta archive: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, summary, fmt.Errorf("failed to write delta archive: %w", err)
+	}
+	return buf.Bytes(), summary, nil
+}
+
+// Delta returns the changes from base to the archive as built by BuildDelta,
+// reusing an earlier result for the same base version
+func (a *Archive) Delta(base *Archive) ([]byte, DeltaSummary, error) {
+	a.convMu.Lock()
+	defer a.convMu.Unlock()
+	if delta, ok := a.deltas[base.ID]; ok {
+		return delta.data, delta.summary, nil
+	}
+
+	data, summary, err := BuildDelta(base, a, a.Fetched)
+	if err != nil {
+		return nil, summary, err
+	}
+	if a.deltas == nil {
+		a.deltas = make(map[string]cachedDelta)
+	}
+	a.deltas[base.ID] = cachedDelta{data: data, summary: summary}
+	return data, summary, nil
+}
+
+// DeltaSize returns the size of a delta built earlier from the version baseID
+func (a *Archive) DeltaSize(baseID string) (int64, bool) {
+	a.convMu.Lock()
+	defer a.convMu.Unlock()
+	delta, ok := a.deltas[baseID]
+	return int64(len(delta.data)), ok
+}
+
+// archiveFiles reads all files of an archive, keyed by their path
+func archiveFiles(archive *Archive) (map[string][]byte, error) {
+	reader, err := archive.zipReader()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		data, err := archive.ReadEntry(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		files[cleanEntryPath(file.Name)] = data
+	}
+	return files, nil
+}
+
+// diffCSV compares two versions of a CSV file row by row. The first line is
+// the header and the first column the row key. A nil version counts as empty.
+func diffCSV(oldData, newData []byte) (delta []byte, added, changed, removed int) {
+	oldHeader, oldRows, oldKeys := splitCSV(oldData)
+	newHeader, newRows, newKeys := splitCSV(newData)
+
+	header := newHeader
+	if newData == nil {
+		header = oldHeader
+	}
+	sep := detectSeparator(header)
+	eol := "\n"
+	if bytes.Contains(newData, []byte("\r\n")) || (newData == nil && bytes.Contains(oldData, []byte("\r\n"))) {
+		eol = "\r\n"
+	}
+
+	var out strings.Builder
+	out.WriteString("change" + sep + header + eol)
+
+	for _, key := range newKeys {
+		old, ok := oldRows[key]
+		switch {
+		case !ok:
+			added++
+			out.WriteString(ChangeAdded + sep + newRows[key] + eol)
+		case old != newRows[key]:
+			changed++
+			out.WriteString(ChangeChanged + sep + newRows[key] + eol)
+		}
+	}
+	for _, key := range oldKeys {
+		if _, ok := newRows[key]; !ok {
+			removed++
+			out.WriteString(ChangeRemoved + sep + oldRows[key] + eol)
+		}
+	}
+
+	return []byte(out.String()), added, changed, removed
+}
+
+// splitCSV returns the header line, the rows by key and the keys in file order
+func splitCSV(data []byte) (string, map[string]string, []string) {
+	rows := make(map[string]string)
+	var keys []string
+
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) == 0 {
+		return "", rows, keys
+	}
+
+	header := lines[0]
+	sep := detectSeparator(header)
+	for _, line := range lines[1:] {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(line, sep)
+		if _, seen := rows[key]; !seen {
+			keys = append(keys, key)
+		}
+		rows[key] = line
+	}
+	return header, rows, keys
+}
+
+// detectSeparator picks the most frequent of ; , and tab in a header line
+func detectSeparator(header string) string {
+	best, count := ";", 0
+	for _, sep := range []string{";", ",", "\t"} {
+		if n := strings.Count(header, sep); n > count {
+			best, count = sep, n
+		}
+	}
+	return best
+}
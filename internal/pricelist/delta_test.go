@@ -0,0 +1,222 @@
+package pricelist
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newArchive builds an archive from file names and contents
+func newArchive(t *testing.T, files map[string]string, fetched time.Time) *Archive {
+	t.Helper()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &Archive{ID: VersionID(buf.Bytes()), Data: buf.Bytes(), Fetched: fetched}
+}
+
+// unzip returns the files of a zip archive
+func unzip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		files[file.Name] = string(content)
+	}
+	return files
+}
+
+func TestDiffCSV(t *testing.T) {
+	tests := []struct {
+		name                    string
+		old, new                string
+		want                    string
+		added, changed, removed int
+	}{
+		{
+			name:    "added, changed and removed rows",
+			old:     "tuote;hinta\n1001;12.50\n1002;3.10\n1003;7.00\n",
+			new:     "tuote;hinta\n1001;12.90\n1003;7.00\n1004;1.00\n",
+			want:    "change;tuote;hinta\nchanged;1001;12.90\nadded;1004;1.00\nremoved;1002;3.10\n",
+			added:   1,
+			changed: 1,
+			removed: 1,
+		},
+		{
+			name: "unchanged",
+			old:  "tuote;hinta\n1001;12.50\n",
+			new:  "tuote;hinta\r\n1001;12.50\r\n",
+			want: "change;tuote;hinta\r\n",
+		},
+		{
+			name:  "new file with commas and CRLF",
+			new:   "tuote,hinta\r\n1001,12.50\r\n",
+			want:  "change,tuote,hinta\r\nadded,1001,12.50\r\n",
+			added: 1,
+		},
+		{
+			name:    "removed file",
+			old:     "tuote\thinta\n1001\t12.50\n",
+			want:    "change\ttuote\thinta\nremoved\t1001\t12.50\n",
+			removed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oldData, newData []byte
+			if tt.old != "" {
+				oldData = []byte(tt.old)
+			}
+			if tt.new != "" {
+				newData = []byte(tt.new)
+			}
+			delta, added, changed, removed := diffCSV(oldData, newData)
+			if string(delta) != tt.want {
+				t.Errorf("delta = %q, want %q", delta, tt.want)
+			}
+			if added != tt.added || changed != tt.changed || removed != tt.removed {
+				t.Errorf("counts = %d/%d/%d, want %d/%d/%d", added, changed, removed, tt.added, tt.changed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestBuildDelta(t *testing.T) {
+	fetched := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+	base := newArchive(t, map[string]string{
+		"hinnat.csv":   "tuote;hinta\n1001;12.50\n1002;3.10\n",
+		"tuotteet.csv": "tuote;nimi\n1001;Hana\n",
+		"ohje.txt":     "old",
+		"logo.png":     "png",
+	}, fetched)
+	target := newArchive(t, map[string]string{
+		"hinnat.csv":   "tuote;hinta\n1001;12.90\n1002;3.10\n",
+		"tuotteet.csv": "tuote;nimi\n1001;Hana\n",
+		"ohje.txt":     "new",
+		"logo.png":     "png",
+	}, fetched.Add(24*time.Hour))
+
+	data, summary, err := BuildDelta(base, target, target.Fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != (DeltaSummary{Changed: 1, Files: 1}) {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	files := unzip(t, data)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "hinnat.csv muutokset.txt ohje.txt" {
+		t.Errorf("delta files = %s", got)
+	}
+	if files["hinnat.csv"] != "change;tuote;hinta\nchanged;1001;12.90\n" {
+		t.Errorf("hinnat.csv = %q", files["hinnat.csv"])
+	}
+	if !strings.Contains(files[deltaInfoName], "Rows changed: 1") || !strings.Contains(files[deltaInfoName], base.ID) {
+		t.Errorf("summary file = %q", files[deltaInfoName])
+	}
+
+	if _, _, err := BuildDelta(base, &Archive{Data: []byte("not a zip")}, fetched); err == nil {
+		t.Error("BuildDelta accepted an invalid archive")
+	}
+}
+
+func TestArchiveDeltaIsReused(t *testing.T) {
+	fetched := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+	base := newArchive(t, map[string]string{"hinnat.csv": "tuote;hinta\n1001;12.50\n"}, fetched)
+	target := newArchive(t, map[string]string{"hinnat.csv": "tuote;hinta\n1001;13.00\n"}, fetched.Add(time.Hour))
+
+	if _, ok := target.DeltaSize(base.ID); ok {
+		t.Fatal("delta size known before the delta was built")
+	}
+	first, _, err := target.Delta(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := target.DeltaSize(base.ID); !ok || size != int64(len(first)) {
+		t.Errorf("DeltaSize = %d, %v; want %d", size, ok, len(first))
+	}
+	second, summary, _ := target.Delta(base)
+	if &first[0] != &second[0] || summary.Changed != 1 {
+		t.Error("delta built again for the same base")
+	}
+	if _, ok := target.DeltaSize("other"); ok {
+		t.Error("delta size known for another base")
+	}
+}
+
+func TestCacheUsesClock(t *testing.T) {
+	now := time.Date(2026, 9, 1, 8, 0, 0, 0, time.UTC)
+	fetches := 0
+	cache := NewCache(15*time.Minute, func(username, apiKey string) ([]byte, error) {
+		fetches++
+		if apiKey == "" {
+			return nil, fmt.Errorf("no API key")
+		}
+		return []byte(fmt.Sprintf("archive %d", fetches)), nil
+	}, func() time.Time { return now })
+
+	if _, ok := cache.Peek("mika"); ok {
+		t.Fatal("Peek returned an archive before any download")
+	}
+	archive, err := cache.Get("mika", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archive.Fetched.Equal(now) {
+		t.Errorf("Fetched = %s, want the clock's %s", archive.Fetched, now)
+	}
+
+	now = now.Add(14 * time.Minute)
+	if again, _ := cache.Get("mika", "key"); again != archive || fetches != 1 {
+		t.Errorf("archive downloaded again within the TTL (%d fetches)", fetches)
+	}
+	if peeked, ok := cache.Peek("mika"); !ok || peeked != archive {
+		t.Error("Peek missed the cached archive")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Peek("mika"); ok {
+		t.Error("Peek returned an expired archive")
+	}
+	if fresh, _ := cache.Get("mika", "key"); fresh == archive || fetches != 2 {
+		t.Errorf("expired archive not downloaded again (%d fetches)", fetches)
+	}
+
+	if _, err := cache.Get("liisa", ""); err == nil {
+		t.Error("failed download returned no error")
+	}
+}
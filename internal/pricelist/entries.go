@@ -0,0 +1,120 @@
+package pricelist
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is a file or directory inside a pricelist archive
+type Entry struct {
+	Name     string // Base name
+	Path     string // Path inside the archive, without leading or trailing slash
+	Size     int64
+	Modified time.Time
+	IsDir    bool
+}
+
+// List returns the entries directly inside dir, "" being the archive root.
+// Directories missing from the archive itself are derived from the entry paths.
+func (a *Archive) List(dir string) ([]Entry, error) {
+	reader, err := a.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	dir = cleanEntryPath(dir)
+
+	seen := make(map[string]bool)
+	var entries []Entry
+	for _, file := range reader.File {
+		name := cleanEntryPath(file.Name)
+		if name == "" || !isBelow(name, dir) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(strings.TrimPrefix(name, dir), "/")
+		child, _, nested := strings.Cut(rest, "/")
+		childPath := path.Join(dir, child)
+		if seen[childPath] {
+			continue
+		}
+		seen[childPath] = true
+
+		if nested || file.FileInfo().IsDir() {
+			entries = append(entries, Entry{Name: child, Path: childPath, Modified: file.Modified, IsDir: true})
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:     child,
+			Path:     childPath,
+			Size:     int64(file.UncompressedSize64),
+			Modified: file.Modified,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Stat returns a single entry of the archive
+func (a *Archive) Stat(name string) (Entry, error) {
+	name = cleanEntryPath(name)
+	if name == "" {
+		return Entry{Name: "", IsDir: true, Modified: a.Fetched}, nil
+	}
+
+	entries, err := a.List(path.Dir(name))
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Path == name {
+			return entry, nil
+		}
+	}
+	return Entry{}, os.ErrNotExist
+}
+
+// ReadEntry returns the uncompressed content of a file in the archive
+func (a *Archive) ReadEntry(name string) ([]byte, error) {
+	reader, err := a.zipReader()
+	if err != nil {
+		return nil, err
+	}
+	name = cleanEntryPath(name)
+
+	for _, file := range reader.File {
+		if cleanEntryPath(file.Name) != name || file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s in pricelist archive: %w", name, err)
+		}
+		defer rc.Close()
+
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s from pricelist archive: %w", name, err)
+		}
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// cleanEntryPath turns an archive or request path into "dir/file" form
+func cleanEntryPath(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))
+	return strings.TrimPrefix(name, "/")
+}
+
+func isBelow(name, dir string) bool {
+	if dir == "" {
+		return true
+	}
+	return strings.HasPrefix(name, dir+"/")
+}
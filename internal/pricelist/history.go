@@ -0,0 +1,37 @@
+package pricelist
+
+import (
+	"strings"
+)
+
+// Dated is a stored version under the dated name it is listed with
+type Dated struct {
+	Name string
+	Version
+}
+
+// DatedVersions names the user's stored versions after the time they were
+// fetched, e.g. salhydro_kaikki_2026-09-01_143000.zip. A name only depends on
+// its own version, so it stays the same while other versions come and go.
+func (s *Store) DatedVersions(username string) []Dated {
+	var dated []Dated
+	for _, version := range s.Versions(username) {
+		dated = append(dated, Dated{Name: datedName(version), Version: version})
+	}
+	return dated
+}
+
+// datedName returns the name a version is listed with
+func datedName(version Version) string {
+	return strings.TrimSuffix(ArchiveName, ".zip") + "_" + version.Fetched.Local().Format("2006-01-02_150405") + ".zip"
+}
+
+// FindDated returns the stored version listed under a dated name
+func (s *Store) FindDated(username, name string) (Dated, bool) {
+	for _, dated := range s.DatedVersions(username) {
+		if dated.Name == name {
+			return dated, true
+		}
+	}
+	return Dated{}, false
+}
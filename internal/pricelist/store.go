@@ -0,0 +1,298 @@
+package pricelist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version identifies one downloaded state of a user's pricelist archive
+type Version struct {
+	ID      string    `json:"id"` // Content hash
+	Fetched time.Time `json:"fetched"`
+	Size    int64     `json:"size"`
+}
+
+// VersionID returns the version ID of archive content
+func VersionID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// downloadState is what the store remembers about a user's downloads
+type downloadState struct {
+	Downloaded string    `json:"downloaded"` // Version the user last downloaded in full
+	At         time.Time `json:"at"`
+}
+
+// maxMemoryVersions bounds the versions kept per user when there is no directory
+const maxMemoryVersions = 10
+
+// Store keeps the full versions of each user's pricelist archive and the
+// version each user last downloaded. With a directory versions are kept as
+// <dir>/<user>/<time>_<id>.zip next to a state.json, otherwise in memory.
+type Store struct {
+	dir       string
+	retention time.Duration    // Versions older than this are dropped, 0 keeps all
+	logger    *log.Logger      // Standard logger when nil
+	now       func() time.Time // Time source for retention and download times
+
+	mu       sync.Mutex
+	versions map[string][]Version // Per user, oldest first
+	data     map[string][]byte    // Version content by user and ID, in memory mode only
+	state    map[string]downloadState
+	loaded   map[string]bool
+}
+
+// NewStore creates a version store
+func NewStore(dir string, retention time.Duration) (*Store, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create pricelist directory: %w", err)
+		}
+	}
+
+	return &Store{
+		dir:       dir,
+		retention: retention,
+		now:       time.Now,
+		versions:  make(map[string][]Version),
+		data:      make(map[string][]byte),
+		state:     make(map[string]downloadState),
+		loaded:    make(map[string]bool),
+	}, nil
+}
+
+// SetLogger sends the store's log output to logger instead of the standard logger
+func (s *Store) SetLogger(logger *log.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+}
+
+// SetClock replaces the time source used for the retention and download times
+func (s *Store) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Save records an archive as the user's newest version unless it is unchanged
+func (s *Store) Save(username string, archive *Archive) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	defer s.pruneLocked(username)
+
+	versions := s.versions[username]
+	if len(versions) > 0 && versions[len(versions)-1].ID == archive.ID {
+		return versions[len(versions)-1], nil
+	}
+
+	version := Version{ID: archive.ID, Fetched: archive.Fetched.Truncate(time.Second), Size: int64(len(archive.Data))}
+	// Each version needs a dated name of its own, which has a resolution of a second
+	for versionNamed(versions, datedName(version)) {
+		version.Fetched = version.Fetched.Add(time.Second)
+	}
+	if s.dir == "" {
+		s.data[username+"\x00"+version.ID] = archive.Data
+	} else {
+		dir := s.userDir(username)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return Version{}, fmt.Errorf("failed to create pricelist directory: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, versionFileName(version)), archive.Data, 0600); err != nil {
+			return Version{}, fmt.Errorf("failed to store pricelist version: %w", err)
+		}
+	}
+
+	s.versions[username] = append(versions, version)
+	return version, nil
+}
+
+// Versions returns the user's stored versions, oldest first
+func (s *Store) Versions(username string) []Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	return append([]Version(nil), s.versions[username]...)
+}
+
+// Load returns the content of a stored version
+func (s *Store) Load(username, id string) (*Archive, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	for _, version := range s.versions[username] {
+		if version.ID != id {
+			continue
+		}
+
+		var data []byte
+		if s.dir == "" {
+			data = s.data[username+"\x00"+id]
+		} else {
+			var err error
+			data, err = os.ReadFile(filepath.Join(s.userDir(username), versionFileName(version)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read pricelist version: %w", err)
+			}
+		}
+		return &Archive{ID: id, Data: data, Fetched: version.Fetched}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// MarkDownloaded records that the user now has the given version
+func (s *Store) MarkDownloaded(username, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	state := downloadState{Downloaded: id, At: s.now()}
+	s.state[username] = state
+
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.userDir(username), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.userDir(username), "state.json"), data, 0600)
+}
+
+// LastDownloaded returns the version the user last downloaded in full
+func (s *Store) LastDownloaded(username string) (Version, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	id := s.state[username].Downloaded
+	for _, version := range s.versions[username] {
+		if version.ID == id {
+			return version, true
+		}
+	}
+	return Version{}, false
+}
+
+// pruneLocked drops versions older than the retention and, in memory, the
+// oldest ones beyond maxMemoryVersions. The newest version and the one the
+// user last downloaded are kept so deltas can still be built.
+func (s *Store) pruneLocked(username string) {
+	versions := s.versions[username]
+	excess := 0
+	if s.dir == "" {
+		excess = len(versions) - maxMemoryVersions
+	}
+	cutoff := s.now().Add(-s.retention)
+
+	kept := versions[:0]
+	for i, version := range versions {
+		expired := s.retention > 0 && !version.Fetched.After(cutoff)
+		if !expired && excess <= 0 || i == len(versions)-1 || version.ID == s.state[username].Downloaded {
+			kept = append(kept, version)
+			continue
+		}
+
+		if s.dir == "" {
+			if !hasVersion(versions[i+1:], version.ID) {
+				delete(s.data, username+"\x00"+version.ID)
+			}
+		} else if err := os.Remove(filepath.Join(s.userDir(username), versionFileName(version))); err != nil && !os.IsNotExist(err) {
+			logTo(s.logger, "Failed to remove pricelist version %s of %s: %v", version.ID, username, err)
+			kept = append(kept, version)
+			continue
+		}
+		excess--
+		logTo(s.logger, "Dropped pricelist version %s of %s from %s", version.ID, username, version.Fetched.Format(time.RFC3339))
+	}
+	s.versions[username] = kept
+}
+
+// hasVersion reports whether versions include id, as when a pricelist returns to earlier content
+func hasVersion(versions []Version, id string) bool {
+	for _, version := range versions {
+		if version.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// versionNamed reports whether one of versions is listed under name
+func versionNamed(versions []Version, name string) bool {
+	for _, version := range versions {
+		if datedName(version) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadLocked reads a user's versions and state from disk the first time they are needed
+func (s *Store) loadLocked(username string) {
+	if s.loaded[username] || s.dir == "" {
+		return
+	}
+	s.loaded[username] = true
+
+	paths, _ := filepath.Glob(filepath.Join(s.userDir(username), "*.zip"))
+	var versions []Version
+	for _, p := range paths {
+		version, ok := parseVersionFileName(filepath.Base(p))
+		if !ok {
+			continue
+		}
+		if info, err := os.Stat(p); err == nil {
+			version.Size = info.Size()
+		}
+		versions = append(versions, version)
+	}
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Fetched.Before(versions[j].Fetched) })
+	s.versions[username] = versions
+
+	if data, err := os.ReadFile(filepath.Join(s.userDir(username), "state.json")); err == nil {
+		var state downloadState
+		if json.Unmarshal(data, &state) == nil {
+			s.state[username] = state
+		}
+	}
+}
+
+func (s *Store) userDir(username string) string {
+	return filepath.Join(s.dir, filepath.Base(username))
+}
+
+const versionTimeFormat = "20060102T150405Z"
+
+func versionFileName(version Version) string {
+	return version.Fetched.UTC().Format(versionTimeFormat) + "_" + version.ID + ".zip"
+}
+
+func parseVersionFileName(name string) (Version, bool) {
+	stamp, id, ok := strings.Cut(strings.TrimSuffix(name, ".zip"), "_")
+	if !ok {
+		return Version{}, false
+	}
+	fetched, err := time.Parse(versionTimeFormat, stamp)
+	if err != nil {
+		return Version{}, false
+	}
+	return Version{ID: id, Fetched: fetched}, true
+}
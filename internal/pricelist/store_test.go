@@ -0,0 +1,170 @@
+package pricelist
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveVersions saves count distinct archives an hour apart and returns their IDs
+func saveVersions(t *testing.T, store *Store, username string, start time.Time, count int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < count; i++ {
+		fetched := start.Add(time.Duration(i) * time.Hour)
+		data := []byte(fmt.Sprintf("archive of %s", fetched))
+		version, err := store.Save(username, &Archive{ID: VersionID(data), Data: data, Fetched: fetched})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, version.ID)
+	}
+	return ids
+}
+
+func TestStoreBoundsMemory(t *testing.T) {
+	store, err := NewStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-48 * time.Hour)
+
+	ids := saveVersions(t, store, "mika", start, 3)
+	if err := store.MarkDownloaded("mika", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, saveVersions(t, store, "mika", start.Add(3*time.Hour), maxMemoryVersions+2)...)
+
+	versions := store.Versions("mika")
+	if len(versions) != maxMemoryVersions {
+		t.Fatalf("%d versions kept, want %d", len(versions), maxMemoryVersions)
+	}
+	if len(store.data) != maxMemoryVersions {
+		t.Errorf("%d archives kept in memory, want %d", len(store.data), maxMemoryVersions)
+	}
+	// The last downloaded version survives, the oldest others go first
+	if versions[0].ID != ids[1] || versions[len(versions)-1].ID != ids[len(ids)-1] {
+		t.Errorf("kept %s ... %s", versions[0].ID, versions[len(versions)-1].ID)
+	}
+	if _, err := store.Load("mika", ids[0]); err == nil {
+		t.Error("oldest version still loadable")
+	}
+	if last, ok := store.LastDownloaded("mika"); !ok || last.ID != ids[1] {
+		t.Errorf("LastDownloaded = %s, %v", last.ID, ok)
+	}
+
+	// Returning to earlier content keeps its data while the newer entry needs it
+	data := []byte("first")
+	for i := 0; i < maxMemoryVersions; i++ {
+		content := data
+		if i%2 == 1 {
+			content = []byte(fmt.Sprintf("other %d", i))
+		}
+		store.Save("pekka", &Archive{ID: VersionID(content), Data: content, Fetched: start.Add(time.Duration(i) * time.Hour)})
+	}
+	saveVersions(t, store, "pekka", start.Add(24*time.Hour), 1)
+	if archive, err := store.Load("pekka", VersionID(data)); err != nil || string(archive.Data) != "first" {
+		t.Errorf("repeated version lost its data: %v", err)
+	}
+
+	// Other users have their own limit
+	saveVersions(t, store, "liisa", start, 2)
+	if got := len(store.Versions("liisa")); got != 2 {
+		t.Errorf("liisa has %d versions", got)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		store, err := NewStore(dir, 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := saveVersions(t, store, "mika", time.Now().Add(-50*time.Hour), 2)
+
+		// Both versions are past the retention, but the newest is kept
+		versions := store.Versions("mika")
+		if len(versions) != 1 || versions[0].ID != ids[1] {
+			t.Errorf("dir %q: versions after retention = %+v", dir, versions)
+		}
+		if archive, err := store.Load("mika", ids[1]); err != nil || VersionID(archive.Data) != ids[1] {
+			t.Errorf("dir %q: Load = %v", dir, err)
+		}
+	}
+}
+
+func TestDatedVersionsAreStable(t *testing.T) {
+	store, err := NewStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-30 * time.Hour)
+
+	// Versions fetched within the same second still get names of their own
+	ids := saveVersions(t, store, "mika", start, 1)
+	data := []byte("same second")
+	same, err := store.Save("mika", &Archive{ID: VersionID(data), Data: data, Fetched: start.Add(time.Millisecond)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = append(ids, same.ID)
+	ids = append(ids, saveVersions(t, store, "mika", start.Add(time.Hour), 2)...)
+
+	names := make(map[string]string)
+	for _, dated := range store.DatedVersions("mika") {
+		names[dated.ID] = dated.Name
+	}
+	if len(names) != 4 {
+		t.Fatalf("dated versions = %v", names)
+	}
+	if want := "salhydro_kaikki_" + start.Local().Format("2006-01-02_150405") + ".zip"; names[ids[0]] != want {
+		t.Errorf("name = %s, want %s", names[ids[0]], want)
+	}
+	if names[ids[0]] == names[ids[1]] {
+		t.Errorf("versions of the same second share the name %s", names[ids[0]])
+	}
+
+	// Dropping the older versions leaves the names of the others as they were
+	store.retention = 29*time.Hour + 30*time.Minute
+	saveVersions(t, store, "mika", time.Now(), 1)
+	for _, dated := range store.DatedVersions("mika") {
+		if name, ok := names[dated.ID]; ok && name != dated.Name {
+			t.Errorf("%s renamed from %s to %s", dated.ID, name, dated.Name)
+		}
+	}
+	if dated, ok := store.FindDated("mika", names[ids[2]]); !ok || dated.ID != ids[2] {
+		t.Errorf("FindDated(%s) = %+v, %v", names[ids[2]], dated, ok)
+	}
+	if _, ok := store.FindDated("mika", names[ids[0]]); ok {
+		t.Error("dropped version still found")
+	}
+}
+
+func TestStoreUsesClock(t *testing.T) {
+	store, err := NewStore("", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store.SetClock(func() time.Time { return past })
+	var logs strings.Builder
+	store.SetLogger(log.New(&logs, "", 0))
+
+	ids := saveVersions(t, store, "mika", past.Add(-30*time.Hour), 1)
+	ids = append(ids, saveVersions(t, store, "mika", past.Add(-2*time.Hour), 2)...)
+
+	// Retention is measured from the store's clock
+	versions := store.Versions("mika")
+	if len(versions) != 2 || versions[0].ID != ids[1] {
+		t.Errorf("versions = %+v", versions)
+	}
+	if !strings.Contains(logs.String(), "Dropped pricelist version "+ids[0]) {
+		t.Errorf("log output = %q", logs.String())
+	}
+	store.MarkDownloaded("mika", ids[2])
+	if state := store.state["mika"]; !state.At.Equal(past) {
+		t.Errorf("download recorded at %s, want %s", state.At, past)
+	}
+}
@@ -0,0 +1,288 @@
+// Package proxyproto reads PROXY protocol v1 and v2 headers, which load
+// balancers such as the AWS NLB put in front of a TCP stream to pass on the
+// client's address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signatureV2 starts a version 2 header
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// prefixV1 starts a version 1 header
+var prefixV1 = []byte("PROXY ")
+
+// maxHeaderV1 is the longest version 1 header including CRLF
+const maxHeaderV1 = 107
+
+// DefaultTimeout is how long a trusted proxy has to send the header
+const DefaultTimeout = 5 * time.Second
+
+// ErrNoHeader is returned when a trusted proxy sends a connection without a PROXY header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Config selects the proxies whose headers are trusted
+type Config struct {
+	Trusted []*net.IPNet  // Connections from these networks must start with a header
+	Timeout time.Duration // DefaultTimeout when zero
+}
+
+// ParseCIDRs parses a list of networks, single addresses are taken as /32 or /128
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q: %w", item, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Listener accepts connections that report the address from their PROXY header
+type Listener struct {
+	net.Listener
+	config Config
+}
+
+// NewListener wraps l. Headers are only read from trusted proxies, other
+// connections are passed on unchanged.
+func NewListener(l net.Listener, config Config) *Listener {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Listener{Listener: l, config: config}
+}
+
+// Accept returns a *Conn without reading from it, so that a slow proxy does
+// not hold up other connections. Call Conn.ReadHeader before using it.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, config: l.config, reader: bufio.NewReader(conn)}, nil
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (c Config) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range c.Trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose addresses come from its PROXY header
+type Conn struct {
+	net.Conn
+	config Config
+	reader *bufio.Reader
+
+	once   sync.Once
+	err    error
+	remote net.Addr // Client address from the header, nil for a direct connection
+	local  net.Addr
+}
+
+// ReadHeader reads the header when the connection comes from a trusted proxy.
+// It is called implicitly by Read and RemoteAddr.
+func (c *Conn) ReadHeader() error {
+	c.once.Do(func() {
+		if !c.config.trusted(c.Conn.RemoteAddr()) {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(c.config.Timeout))
+		c.err = c.readHeader()
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+	return c.err
+}
+
+// ProxyAddr returns the address of the proxy that forwarded the connection
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.ReadHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header, or the peer address of a direct connection
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.ReadHeader() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to from the header, or the local address
+func (c *Conn) LocalAddr() net.Addr {
+	if c.ReadHeader() == nil && c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite closes the sending side of a TCP connection
+func (c *Conn) CloseWrite() error {
+	if tcpConn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return tcpConn.CloseWrite()
+	}
+	return nil
+}
+
+// readHeader tells the versions apart by their first 6 bytes, fewer than the
+// shortest v1 header "PROXY UNKNOWN\r\n", so that it never waits for more
+// than the proxy sends before the client's data
+func (c *Conn) readHeader() error {
+	peek, err := c.peek(len(prefixV1))
+	if err != nil {
+		return err
+	}
+	switch {
+	case bytes.Equal(peek, prefixV1):
+		return c.readV1()
+	case !bytes.Equal(peek, signatureV2[:len(prefixV1)]):
+		return ErrNoHeader
+	}
+
+	if peek, err = c.peek(len(signatureV2)); err != nil {
+		return err
+	}
+	if !bytes.Equal(peek, signatureV2) {
+		return ErrNoHeader
+	}
+	return c.readV2()
+}
+
+// peek returns the next n bytes without consuming them
+func (c *Conn) peek(n int) ([]byte, error) {
+	peek, err := c.reader.Peek(n)
+	if err == nil {
+		return peek, nil
+	}
+	if len(peek) == 0 && errors.Is(err, io.EOF) {
+		return nil, err // A health check that connected and closed again
+	}
+	if !bytes.HasPrefix(signatureV2, peek) && !bytes.HasPrefix(prefixV1, peek) {
+		return nil, ErrNoHeader
+	}
+	return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <srcport> <dstport>\r\n"
+func (c *Conn) readV1() error {
+	var line []byte
+	for len(line) < maxHeaderV1 {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read PROXY header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("PROXY v1 header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	src, err := parseAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func parseAddr(ip, port string) (*net.TCPAddr, error) {
+	parsedIP := net.ParseIP(ip)
+	parsedPort, err := strconv.Atoi(port)
+	if parsedIP == nil || err != nil || parsedPort < 0 || parsedPort > 65535 {
+		return nil, fmt.Errorf("malformed PROXY v1 address %s:%s", ip, port)
+	}
+	return &net.TCPAddr{IP: parsedIP, Port: parsedPort}, nil
+}
+
+// readV2 parses the binary header: signature, version and command, family,
+// length, addresses and TLVs, which are skipped
+func (c *Conn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	command, family := header[12]&0x0f, header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+
+	// LOCAL connections come from the proxy itself, e.g. health checks
+	if command == 0 {
+		return nil
+	}
+	if command != 1 {
+		return fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return fmt.Errorf("short PROXY v2 IPv4 address block")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}
+		c.local = &net.TCPAddr{IP: net.IP(body[4:8]), Port: int(binary.BigEndian.Uint16(body[10:12]))}
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return fmt.Errorf("short PROXY v2 IPv6 address block")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}
+		c.local = &net.TCPAddr{IP: net.IP(body[16:32]), Port: int(binary.BigEndian.Uint16(body[34:36]))}
+	default:
+		// UNSPEC or a non-TCP family, keep the connection's own addresses
+	}
+	return nil
+}
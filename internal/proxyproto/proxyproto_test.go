@@ -0,0 +1,300 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// headerV2 builds a version 2 header with the given command, family and address block
+func headerV2(command, family byte, body []byte) []byte {
+	header := append([]byte{}, signatureV2...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+func ipv4Block(src, dst string, srcPort, dstPort uint16) []byte {
+	block := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	block = binary.BigEndian.AppendUint16(block, srcPort)
+	return binary.BigEndian.AppendUint16(block, dstPort)
+}
+
+func ipv6Block(src, dst string, srcPort, dstPort uint16) []byte {
+	block := append(net.ParseIP(src).To16(), net.ParseIP(dst).To16()...)
+	block = binary.BigEndian.AppendUint16(block, srcPort)
+	return binary.BigEndian.AppendUint16(block, dstPort)
+}
+
+// accept sends data over a new connection to a listener trusting the loopback
+// network, keeping the connection open unless closeAfter is set
+func accept(t *testing.T, trusted string, data []byte, closeAfter bool) *Conn {
+	t.Helper()
+	networks, err := ParseCIDRs([]string{trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inner.Close() })
+	listener := NewListener(inner, Config{Trusted: networks, Timeout: 2 * time.Second})
+
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if closeAfter {
+		client.Close()
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*Conn)
+}
+
+func TestReadHeader(t *testing.T) {
+	tlv := []byte{0x03, 0x00, 0x04, 'c', 'r', 'c', '!'}
+	tests := []struct {
+		name       string
+		data       []byte
+		closeAfter bool
+		remote     string // Expected client address, empty for the peer's own
+		local      string
+		wantErr    string
+		payload    string // Data expected after the header
+	}{
+		{
+			name:    "v1 TCP4",
+			data:    []byte("PROXY TCP4 203.0.113.7 10.0.0.5 40001 22\r\nSSH-2.0-client\r\n"),
+			remote:  "203.0.113.7:40001",
+			local:   "10.0.0.5:22",
+			payload: "SSH-2.0-client\r\n",
+		},
+		{
+			name:   "v1 TCP6",
+			data:   []byte("PROXY TCP6 2001:db8::7 2001:db8::1 40001 22\r\n"),
+			remote: "[2001:db8::7]:40001",
+			local:  "[2001:db8::1]:22",
+		},
+		{
+			// 15 bytes and nothing more, which must not wait for further data
+			name: "v1 UNKNOWN alone",
+			data: []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:    "v1 UNKNOWN with addresses",
+			data:    []byte("PROXY UNKNOWN ff:: ff:: 1 2\r\nx"),
+			payload: "x",
+		},
+		{
+			name:    "v1 bad address",
+			data:    []byte("PROXY TCP4 203.0.113.300 10.0.0.5 40001 22\r\n"),
+			wantErr: "malformed PROXY v1 address",
+		},
+		{
+			name:    "v1 bad port",
+			data:    []byte("PROXY TCP4 203.0.113.7 10.0.0.5 70000 22\r\n"),
+			wantErr: "malformed PROXY v1 address",
+		},
+		{
+			name:    "v1 missing fields",
+			data:    []byte("PROXY TCP4 203.0.113.7\r\n"),
+			wantErr: "malformed PROXY v1 header",
+		},
+		{
+			name:    "v1 too long",
+			data:    []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"),
+			wantErr: "too long",
+		},
+		{
+			name:       "v1 truncated",
+			data:       []byte("PROXY TCP4 203.0.113.7"),
+			closeAfter: true,
+			wantErr:    "failed to read PROXY header",
+		},
+		{
+			name:    "v2 IPv4 with TLV",
+			data:    append(headerV2(1, 0x11, append(ipv4Block("198.51.100.9", "10.0.0.5", 50123, 22), tlv...)), "SSH-2.0-x\r\n"...),
+			remote:  "198.51.100.9:50123",
+			local:   "10.0.0.5:22",
+			payload: "SSH-2.0-x\r\n",
+		},
+		{
+			name:   "v2 IPv6",
+			data:   headerV2(1, 0x21, ipv6Block("2001:db8::9", "2001:db8::1", 50123, 22)),
+			remote: "[2001:db8::9]:50123",
+			local:  "[2001:db8::1]:22",
+		},
+		{
+			name:    "v2 LOCAL keeps the proxy address",
+			data:    append(headerV2(0, 0x00, nil), "SSH-2.0-x\r\n"...),
+			payload: "SSH-2.0-x\r\n",
+		},
+		{
+			name: "v2 UNSPEC keeps the proxy address",
+			data: headerV2(1, 0x00, []byte{1, 2, 3}),
+		},
+		{
+			name:    "v2 short IPv4 block",
+			data:    headerV2(1, 0x11, []byte{1, 2, 3, 4}),
+			wantErr: "short PROXY v2 IPv4",
+		},
+		{
+			name:    "v2 short IPv6 block",
+			data:    headerV2(1, 0x21, make([]byte, 20)),
+			wantErr: "short PROXY v2 IPv6",
+		},
+		{
+			name:    "v2 unknown command",
+			data:    headerV2(5, 0x11, ipv4Block("198.51.100.9", "10.0.0.5", 1, 2)),
+			wantErr: "unsupported PROXY v2 command",
+		},
+		{
+			name:    "v2 wrong version",
+			data:    append(append(append([]byte{}, signatureV2...), 0x11, 0x11), 0, 0),
+			wantErr: "unsupported PROXY protocol version",
+		},
+		{
+			name:       "v2 truncated body",
+			data:       append(headerV2(1, 0x11, nil)[:14], 0, 12, 1, 2),
+			closeAfter: true,
+			wantErr:    "failed to read PROXY header",
+		},
+		{
+			name:    "SSH without header",
+			data:    []byte("SSH-2.0-OpenSSH_9.6\r\n"),
+			wantErr: ErrNoHeader.Error(),
+		},
+		{
+			name:    "v2 signature mismatch",
+			data:    []byte("\r\n\r\n\x00\rXQUIT\n!!!!"),
+			wantErr: ErrNoHeader.Error(),
+		},
+		{
+			name:       "short non-header",
+			data:       []byte("SSH"),
+			closeAfter: true,
+			wantErr:    ErrNoHeader.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := accept(t, "127.0.0.0/8", tt.data, tt.closeAfter)
+			proxyAddr := conn.ProxyAddr().String()
+
+			err := conn.ReadHeader()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadHeader error = %v, want %q", err, tt.wantErr)
+				}
+				if _, readErr := conn.Read(make([]byte, 1)); readErr != err {
+					t.Errorf("Read after a failed header returned %v", readErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadHeader: %v", err)
+			}
+
+			wantRemote, wantLocal := tt.remote, tt.local
+			if wantRemote == "" {
+				wantRemote, wantLocal = proxyAddr, conn.Conn.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr = %s, want %s", got, wantRemote)
+			}
+			if got := conn.LocalAddr().String(); got != wantLocal {
+				t.Errorf("LocalAddr = %s, want %s", got, wantLocal)
+			}
+			if got := conn.ProxyAddr().String(); got != proxyAddr {
+				t.Errorf("ProxyAddr = %s, want %s", got, proxyAddr)
+			}
+
+			if tt.payload != "" {
+				buf := make([]byte, len(tt.payload))
+				if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != tt.payload {
+					t.Errorf("payload = %q, %v; want %q", buf, err, tt.payload)
+				}
+			}
+		})
+	}
+}
+
+func TestReadHeaderHealthCheck(t *testing.T) {
+	conn := accept(t, "127.0.0.0/8", nil, true)
+	if err := conn.ReadHeader(); !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadHeader error = %v, want EOF", err)
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	conn := accept(t, "127.0.0.0/8", []byte("PROX"), false)
+	conn.config.Timeout = 100 * time.Millisecond
+
+	started := time.Now()
+	err := conn.ReadHeader()
+	if err == nil || !strings.Contains(err.Error(), "failed to read PROXY header") {
+		t.Fatalf("ReadHeader error = %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("ReadHeader took %s", elapsed)
+	}
+}
+
+func TestUntrustedPeerPassesThrough(t *testing.T) {
+	data := "PROXY TCP4 203.0.113.7 10.0.0.5 40001 22\r\n"
+	conn := accept(t, "192.0.2.0/24", []byte(data), false)
+
+	if err := conn.ReadHeader(); err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if got, want := conn.RemoteAddr().String(), conn.ProxyAddr().String(); got != want {
+		t.Errorf("RemoteAddr = %s, want the peer address %s", got, want)
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != data {
+		t.Errorf("Read = %q, %v; want the header untouched", buf, err)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		input   []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, nil, false},
+		{[]string{"10.0.0.0/16", "10.1.2.3", "2001:db8::1", "2001:db8::/32"},
+			[]string{"10.0.0.0/16", "10.1.2.3/32", "2001:db8::1/128", "2001:db8::/32"}, false},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"load-balancer"}, nil, true},
+	}
+	for _, tt := range tests {
+		networks, err := ParseCIDRs(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCIDRs(%v) error = %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, network := range networks {
+			got = append(got, network.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("ParseCIDRs(%v) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
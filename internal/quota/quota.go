@@ -0,0 +1,167 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits are upload quotas for a user or an inbound folder. Zero means unlimited.
+type Limits struct {
+	FilesPerHour int   `json:"files_per_hour,omitempty"`
+	FilesPerDay  int   `json:"files_per_day,omitempty"`
+	BytesPerDay  int64 `json:"bytes_per_day,omitempty"`
+	MaxFileSize  int64 `json:"max_file_size,omitempty"`
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Usage is what has been uploaded within the quota windows
+type Usage struct {
+	FilesLastHour int
+	FilesLastDay  int
+	BytesLastDay  int64
+}
+
+// Error is returned when an upload would exceed a quota
+type Error struct {
+	Scope  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("quota exceeded for %s: %s", e.Scope, e.Reason)
+}
+
+// RemainingBytes returns how many bytes can still be uploaded today, -1 meaning unlimited
+func (l Limits) RemainingBytes(u Usage) int64 {
+	if l.BytesPerDay == 0 {
+		return -1
+	}
+	return max(l.BytesPerDay-u.BytesLastDay, 0)
+}
+
+// RemainingFiles returns how many files can still be uploaded, -1 meaning unlimited
+func (l Limits) RemainingFiles(u Usage) int {
+	remaining := -1
+	if l.FilesPerHour > 0 {
+		remaining = max(l.FilesPerHour-u.FilesLastHour, 0)
+	}
+	if l.FilesPerDay > 0 {
+		daily := max(l.FilesPerDay-u.FilesLastDay, 0)
+		if remaining < 0 || daily < remaining {
+			remaining = daily
+		}
+	}
+	return remaining
+}
+
+type upload struct {
+	at   time.Time
+	size int64
+}
+
+// Tracker counts uploads per key within a sliding 24 hour window. It is shared
+// by all sessions of the server process.
+type Tracker struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	uploads map[string][]upload
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		now:     time.Now,
+		uploads: make(map[string][]upload),
+	}
+}
+
+// SetClock replaces the time source used for the quota windows
+func (t *Tracker) SetClock(now func() time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// Key builds the tracker key for a user, optionally scoped to a folder
+func Key(username, folder string) string {
+	return username + "\x00" + folder
+}
+
+// Usage returns the current usage for a key
+func (t *Tracker) Usage(key string) Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usageLocked(key)
+}
+
+func (t *Tracker) usageLocked(key string) Usage {
+	now := t.now()
+	hourAgo := now.Add(-time.Hour)
+	dayAgo := now.Add(-24 * time.Hour)
+
+	// Drop uploads that have left the daily window
+	uploads := t.uploads[key]
+	for len(uploads) > 0 && !uploads[0].at.After(dayAgo) {
+		uploads = uploads[1:]
+	}
+	if len(uploads) == 0 {
+		delete(t.uploads, key)
+	} else {
+		t.uploads[key] = uploads
+	}
+
+	var usage Usage
+	for _, u := range uploads {
+		usage.FilesLastDay++
+		usage.BytesLastDay += u.size
+		if u.at.After(hourAgo) {
+			usage.FilesLastHour++
+		}
+	}
+	return usage
+}
+
+// CheckOpen checks whether a new file may be started under the limits
+func (t *Tracker) CheckOpen(key, scope string, limits Limits) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	usage := t.usageLocked(key)
+	if limits.FilesPerHour > 0 && usage.FilesLastHour >= limits.FilesPerHour {
+		return &Error{Scope: scope, Reason: fmt.Sprintf("%d files per hour", limits.FilesPerHour)}
+	}
+	if limits.FilesPerDay > 0 && usage.FilesLastDay >= limits.FilesPerDay {
+		return &Error{Scope: scope, Reason: fmt.Sprintf("%d files per day", limits.FilesPerDay)}
+	}
+	if limits.BytesPerDay > 0 && usage.BytesLastDay >= limits.BytesPerDay {
+		return &Error{Scope: scope, Reason: fmt.Sprintf("%d bytes per day", limits.BytesPerDay)}
+	}
+	return nil
+}
+
+// CheckSize checks whether a file that has grown to size still fits the limits
+func (t *Tracker) CheckSize(key, scope string, limits Limits, size int64) error {
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		return &Error{Scope: scope, Reason: fmt.Sprintf("maximum file size %d bytes", limits.MaxFileSize)}
+	}
+
+	if limits.BytesPerDay > 0 {
+		usage := t.Usage(key)
+		if usage.BytesLastDay+size > limits.BytesPerDay {
+			return &Error{Scope: scope, Reason: fmt.Sprintf("%d bytes per day", limits.BytesPerDay)}
+		}
+	}
+	return nil
+}
+
+// Record counts a delivered upload against a key
+func (t *Tracker) Record(key string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.uploads[key] = append(t.uploads[key], upload{at: t.now(), size: size})
+}
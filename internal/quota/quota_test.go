@@ -0,0 +1,114 @@
+package quota
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackerWindows(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.SetClock(func() time.Time { return now })
+	key := Key("mika", "/in")
+
+	record := func(at time.Time, size int64) {
+		saved := now
+		now = at
+		tracker.Record(key, size)
+		now = saved
+	}
+	record(now.Add(-25*time.Hour), 1000) // Outside both windows
+	record(now.Add(-24*time.Hour), 1000) // Exactly a day ago, already outside
+	record(now.Add(-23*time.Hour), 300)
+	record(now.Add(-61*time.Minute), 200)
+	record(now.Add(-59*time.Minute), 100)
+	record(now, 50)
+
+	if got, want := tracker.Usage(key), (Usage{FilesLastHour: 2, FilesLastDay: 4, BytesLastDay: 650}); got != want {
+		t.Errorf("Usage = %+v, want %+v", got, want)
+	}
+	if got := tracker.Usage(Key("mika", "/in/orders")); got != (Usage{}) {
+		t.Errorf("usage of another folder = %+v", got)
+	}
+
+	// The windows slide with the clock
+	now = now.Add(2 * time.Hour)
+	if got, want := tracker.Usage(key), (Usage{FilesLastDay: 3, BytesLastDay: 350}); got != want {
+		t.Errorf("Usage two hours later = %+v, want %+v", got, want)
+	}
+	now = now.Add(24 * time.Hour)
+	if got := tracker.Usage(key); got != (Usage{}) {
+		t.Errorf("Usage a day later = %+v", got)
+	}
+}
+
+func TestTrackerChecks(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.SetClock(func() time.Time { return now })
+	key := Key("mika", "")
+	tracker.Record(key, 400)
+	tracker.Record(key, 400)
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		size    int64
+		wantErr string // From CheckOpen, or CheckSize when size is set
+	}{
+		{"unlimited", Limits{}, 10000, ""},
+		{"hourly files left", Limits{FilesPerHour: 3}, 0, ""},
+		{"hourly files used up", Limits{FilesPerHour: 2}, 0, "2 files per hour"},
+		{"daily files used up", Limits{FilesPerDay: 2}, 0, "2 files per day"},
+		{"daily bytes used up", Limits{BytesPerDay: 800}, 0, "800 bytes per day"},
+		{"file fits the daily bytes", Limits{BytesPerDay: 1000}, 200, ""},
+		{"file exceeds the daily bytes", Limits{BytesPerDay: 1000}, 201, "1000 bytes per day"},
+		{"file too large", Limits{MaxFileSize: 100}, 101, "maximum file size 100 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.size > 0 {
+				err = tracker.CheckSize(key, "user mika", tt.limits, tt.size)
+			} else {
+				err = tracker.CheckOpen(key, "user mika", tt.limits)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var quotaErr *Error
+			if !errors.As(err, &quotaErr) || quotaErr.Scope != "user mika" || !strings.Contains(quotaErr.Reason, tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	usage := Usage{FilesLastHour: 4, FilesLastDay: 9, BytesLastDay: 700}
+
+	tests := []struct {
+		limits Limits
+		files  int
+		bytes  int64
+	}{
+		{Limits{}, -1, -1},
+		{Limits{FilesPerHour: 10}, 6, -1},
+		{Limits{FilesPerHour: 10, FilesPerDay: 12}, 3, -1},
+		{Limits{FilesPerDay: 5, BytesPerDay: 500}, 0, 0},
+		{Limits{BytesPerDay: 1000}, -1, 300},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.RemainingFiles(usage); got != tt.files {
+			t.Errorf("%+v: RemainingFiles = %d, want %d", tt.limits, got, tt.files)
+		}
+		if got := tt.limits.RemainingBytes(usage); got != tt.bytes {
+			t.Errorf("%+v: RemainingBytes = %d, want %d", tt.limits, got, tt.bytes)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package sftp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/spool"
+	"sftp-service/internal/storage"
+)
+
+// holdForBatch keeps a validated upload in the spool until its batch is committed
+func (w *incomingWriterAt) holdForBatch(data []byte, metadata *storage.UploadMetadata) error {
+	var rawMetadata json.RawMessage
+	if metadata != nil {
+		rawMetadata, _ = json.Marshal(metadata)
+	}
+
+	if w.fs.services.Spool == nil {
+		return fmt.Errorf("batch uploads are not available: no spool configured")
+	}
+	entry := w.entry(spool.StatusPending, "held until the batch is committed")
+	if err := w.fs.services.Spool.Hold(w.username, spool.HeldFile{Entry: entry, Data: data, Metadata: rawMetadata}); err != nil {
+		w.fs.services.logf("Failed to hold upload %s/%s: %v", w.username, w.filename, err)
+		return fmt.Errorf("failed to store upload")
+	}
+
+	w.held = true
+	w.fs.services.Spool.Record(w.username, entry)
+	w.fs.services.expireHeld(w.username, entry, time.Duration(w.folder.Batch.Timeout))
+
+	w.fs.services.logf("Upload %s/%s held for batch in %s", w.username, w.filename, w.folder.Path)
+	return nil
+}
+
+// batchTriggerWriterAt receives a trigger or manifest file and commits the batch when it is closed
+type batchTriggerWriterAt struct {
+	fs     *APIFileSystem
+	folder *inbound.Folder
+	name   string
+	data   []byte
+	err    error // Set when the connection dropped mid-transfer
+}
+
+func (w *batchTriggerWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	needed := int(off) + len(p)
+	if w.folder.MaxSize > 0 && int64(needed) > w.folder.MaxSize {
+		return 0, fmt.Errorf("manifest exceeds maximum size of %d bytes", w.folder.MaxSize)
+	}
+
+	if needed > len(w.data) {
+		newData := make([]byte, needed)
+		copy(newData, w.data)
+		w.data = newData
+	}
+
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+// TransferError keeps an interrupted trigger or manifest from committing the batch
+func (w *batchTriggerWriterAt) TransferError(err error) {
+	w.err = err
+}
+
+func (w *batchTriggerWriterAt) Close() error {
+	if w.err != nil {
+		w.fs.services.logf("Batch trigger %s/%s interrupted, batch not committed: %v", w.fs.username, w.name, w.err)
+		return w.err
+	}
+	return w.fs.commitBatch(w.folder, w.name, w.data)
+}
+
+// commitBatch delivers the held files a trigger or manifest file refers to as
+// one request. The batch is delivered or rejected as a whole.
+func (fs *APIFileSystem) commitBatch(folder *inbound.Folder, trigger string, manifest []byte) error {
+	if fs.services.Spool == nil {
+		return fmt.Errorf("batch uploads are not available: no spool configured")
+	}
+	held := fs.services.Spool.Held(fs.username, folder.Path)
+
+	var members []spool.HeldFile
+	var missing []string
+	if base, ok := folder.Batch.TriggerBase(trigger); ok {
+		for _, file := range held {
+			if inbound.BatchMember(base, file.Entry.Name) {
+				members = append(members, file)
+			}
+		}
+	} else {
+		listed := inbound.ParseManifest(manifest)
+		if len(listed) == 0 {
+			return fmt.Errorf("manifest %s lists no files", trigger)
+		}
+		for _, name := range listed {
+			found := false
+			for _, file := range held {
+				if file.Entry.Name == name {
+					members = append(members, file)
+					found = true
+				}
+			}
+			if !found {
+				missing = append(missing, name)
+			}
+		}
+	}
+
+	// Take the files out of the spool so a concurrent trigger or the timeout cannot deliver them twice
+	var files []spool.HeldFile
+	for _, member := range members {
+		if file, ok := fs.services.Spool.TakeHeld(fs.username, member.Entry.ID); ok {
+			files = append(files, file)
+		}
+	}
+
+	if len(missing) > 0 {
+		err := fmt.Errorf("batch %s incomplete, missing: %s", trigger, strings.Join(missing, ", "))
+		fs.services.logf("Batch rejected: %s: %v", fs.username, err)
+		fs.services.failHeld(fs.username, files, err.Error())
+		return err
+	}
+	if len(files) == 0 {
+		fs.services.logf("Batch trigger %s from user %s matched no held files", trigger, fs.username)
+		return fmt.Errorf("no files held for batch %s", trigger)
+	}
+
+	var batchFiles []storage.BatchFile
+	for _, file := range files {
+		batchFile := storage.BatchFile{Filename: file.Entry.Name, Data: file.Data}
+		if len(file.Metadata) > 0 {
+			batchFile.Metadata = &storage.UploadMetadata{}
+			if err := json.Unmarshal(file.Metadata, batchFile.Metadata); err != nil {
+				batchFile.Metadata = nil
+			}
+		}
+		batchFiles = append(batchFiles, batchFile)
+	}
+
+	err := fs.services.api().SendBatchToAPI(fs.apiURL, folder.Batch.Endpoint, folder.Envelope, fs.username, fs.apiKey, trigger, batchFiles)
+	if err != nil {
+		var apiErr *storage.APIError
+		if errors.As(err, &apiErr) && apiErr.Rejected() {
+			fs.services.failHeld(fs.username, files, err.Error())
+			for _, file := range files {
+				fs.services.keepRejected(fs.username, folder, file.Entry.Name, file.Data, apiErr)
+			}
+			return err
+		}
+
+		// The API did not decide on the batch, keep it for the next trigger
+		fs.services.logf("Batch %s from user %s not delivered, files stay held: %v", trigger, fs.username, err)
+		fs.services.reholdFiles(fs.username, files, err)
+		return err
+	}
+
+	for _, file := range files {
+		fs.recordUpload(folder, file.Entry.Size)
+
+		entry := file.Entry
+		entry.Status = spool.StatusDelivered
+		entry.Reason = ""
+		fs.services.Spool.Record(fs.username, entry)
+	}
+	return nil
+}
+
+// failHeld marks held files that will not be delivered as failed
+func (s *Services) failHeld(username string, files []spool.HeldFile, reason string) {
+	for _, file := range files {
+		entry := file.Entry
+		entry.Status = spool.StatusFailed
+		entry.Reason = reason
+		s.Spool.Record(username, entry)
+	}
+}
+
+// reholdFiles puts files back into the spool after a failed delivery. Their
+// original expiry still applies.
+func (s *Services) reholdFiles(username string, files []spool.HeldFile, cause error) {
+	for _, file := range files {
+		if err := s.Spool.Hold(username, file); err != nil {
+			s.logf("Failed to hold upload %s/%s again: %v", username, file.Entry.Name, err)
+			s.failHeld(username, []spool.HeldFile{file}, cause.Error())
+			continue
+		}
+		entry := file.Entry
+		entry.Status = spool.StatusPending
+		entry.Reason = fmt.Sprintf("held, delivery failed: %v", cause)
+		s.Spool.Record(username, entry)
+	}
+}
+
+// expireHeld rejects a held file when its batch is not committed within the timeout
+func (s *Services) expireHeld(username string, entry spool.Entry, timeout time.Duration) {
+	time.AfterFunc(entry.Time.Add(timeout).Sub(s.now()), func() {
+		file, ok := s.Spool.TakeHeld(username, entry.ID)
+		if !ok {
+			return
+		}
+		s.logf("Held upload %s/%s expired, batch not committed within %s", username, entry.Name, timeout)
+		s.failHeld(username, []spool.HeldFile{file}, fmt.Sprintf("batch not committed within %s", timeout))
+	})
+}
+
+// restoreHeld schedules the timeouts of files held by a previous run
+func (s *Services) restoreHeld() error {
+	if s.Spool == nil {
+		return nil
+	}
+	held, err := s.Spool.LoadHeld()
+	if err != nil {
+		return err
+	}
+
+	for username, files := range held {
+		for _, file := range files {
+			var timeout time.Duration
+			if folder, ok := s.Folders.Folder(file.Entry.Folder); ok && folder.Batch != nil {
+				timeout = time.Duration(folder.Batch.Timeout)
+			}
+			s.expireHeld(username, file.Entry, timeout)
+		}
+		s.logf("Restored %d held uploads for %s", len(files), username)
+	}
+	return nil
+}
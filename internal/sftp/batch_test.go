@@ -0,0 +1,238 @@
+package sftp
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/quota"
+	"sftp-service/internal/spool"
+	"sftp-service/internal/storage"
+)
+
+// batchFileSystem returns a file system for mika whose API answers batches with status
+func batchFileSystem(t *testing.T, status *int, received *[]string) *APIFileSystem {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/futur/order/batch" {
+			http.NotFound(w, r)
+			return
+		}
+		var batch storage.BatchRequest
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, file := range batch.Files {
+			*received = append(*received, file.Filename)
+		}
+		w.WriteHeader(*status)
+	}))
+	t.Cleanup(server.Close)
+
+	held, err := spool.New("", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &APIFileSystem{
+		apiURL:   server.URL,
+		username: "mika",
+		apiKey:   "key",
+		services: &Services{
+			Spool:  held,
+			Quotas: quota.NewTracker(),
+			Logger: log.New(io.Discard, "", 0),
+		},
+	}
+}
+
+// hold puts uploads for folder into the spool
+func hold(t *testing.T, fs *APIFileSystem, folder string, names ...string) {
+	t.Helper()
+	now := time.Now()
+	for i, name := range names {
+		at := now.Add(time.Duration(i) * time.Second)
+		entry := spool.Entry{ID: spool.NewID(at, name), Folder: folder, Name: name, Size: 4, Time: at, Status: spool.StatusPending}
+		if err := fs.services.Spool.Hold(fs.username, spool.HeldFile{Entry: entry, Data: []byte("data")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// heldNames returns the names of the files still held in folder
+func heldNames(fs *APIFileSystem, folder string) []string {
+	var names []string
+	for _, file := range fs.services.Spool.Held(fs.username, folder) {
+		names = append(names, file.Entry.Name)
+	}
+	return names
+}
+
+func TestCommitBatch(t *testing.T) {
+	folder := &inbound.Folder{
+		Path:     "/in/batch",
+		Endpoint: "/api/futur/order",
+		Envelope: inbound.EnvelopeOrder,
+		Batch:    &inbound.BatchPolicy{Triggers: []string{".done"}, Manifest: "*.manifest", Endpoint: "/api/futur/order/batch"},
+	}
+
+	tests := []struct {
+		name      string
+		trigger   string
+		manifest  string
+		status    int
+		wantErr   bool
+		delivered []string // Files the API received
+		held      []string // Files still held afterwards
+		rejected  int      // Files kept in the rejected folder
+	}{
+		{
+			name:      "trigger commits its members",
+			trigger:   "order1.done",
+			status:    http.StatusOK,
+			delivered: []string{"order1.csv", "order1_lines.csv"},
+			held:      []string{"order2.csv"},
+		},
+		{
+			name:      "manifest commits the listed files",
+			trigger:   "week.manifest",
+			manifest:  "order1.csv\norder2.csv\n",
+			status:    http.StatusOK,
+			delivered: []string{"order1.csv", "order2.csv"},
+			held:      []string{"order1_lines.csv"},
+		},
+		{
+			name:     "incomplete manifest fails the listed files",
+			trigger:  "week.manifest",
+			manifest: "order1.csv\norder3.csv\n",
+			status:   http.StatusOK,
+			wantErr:  true,
+			held:     []string{"order1_lines.csv", "order2.csv"},
+		},
+		{
+			name:    "trigger without held files",
+			trigger: "order9.done",
+			status:  http.StatusOK,
+			wantErr: true,
+			held:    []string{"order1.csv", "order1_lines.csv", "order2.csv"},
+		},
+		{
+			name:      "rejected batch is kept as rejected files",
+			trigger:   "order1.done",
+			status:    http.StatusUnprocessableEntity,
+			wantErr:   true,
+			delivered: []string{"order1.csv", "order1_lines.csv"},
+			held:      []string{"order2.csv"},
+			rejected:  2,
+		},
+		{
+			name:      "failed delivery holds the files again",
+			trigger:   "order1.done",
+			status:    http.StatusServiceUnavailable,
+			wantErr:   true,
+			delivered: []string{"order1.csv", "order1_lines.csv"},
+			held:      []string{"order1.csv", "order1_lines.csv", "order2.csv"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delivered []string
+			status := tt.status
+			fs := batchFileSystem(t, &status, &delivered)
+			hold(t, fs, folder.Path, "order1.csv", "order1_lines.csv", "order2.csv")
+			hold(t, fs, "/in", "order1.csv")
+
+			err := fs.commitBatch(folder, tt.trigger, []byte(tt.manifest))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("commitBatch error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(delivered, tt.delivered) {
+				t.Errorf("delivered %v, want %v", delivered, tt.delivered)
+			}
+			if held := heldNames(fs, folder.Path); !slices.Equal(held, tt.held) {
+				t.Errorf("held %v, want %v", held, tt.held)
+			}
+			if held := heldNames(fs, "/in"); len(held) != 1 {
+				t.Errorf("files of another folder touched: %v", held)
+			}
+			if files, _ := fs.services.Spool.Files(spool.AreaRejected, "mika"); len(files) != tt.rejected*2 {
+				t.Errorf("%d rejected files, want %d with their reasons", len(files), tt.rejected*2)
+			}
+
+			wantQuota := 0
+			if tt.status == http.StatusOK && !tt.wantErr {
+				wantQuota = len(tt.delivered)
+			}
+			if usage := fs.services.Quotas.Usage(quota.Key("mika", folder.Path)); usage.FilesLastDay != wantQuota {
+				t.Errorf("quota counts %d files, want %d", usage.FilesLastDay, wantQuota)
+			}
+		})
+	}
+}
+
+func TestExpireHeldUsesClock(t *testing.T) {
+	var delivered []string
+	status := http.StatusOK
+	fs := batchFileSystem(t, &status, &delivered)
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fs.services.Clock = func() time.Time { return past }
+
+	held := func(name string) bool {
+		for _, file := range fs.services.Spool.Held("mika", "/in/batch") {
+			if file.Entry.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	for _, test := range []struct {
+		name    string
+		timeout time.Duration
+	}{{"slow.csv", time.Hour}, {"quick.csv", 10 * time.Millisecond}} {
+		entry := spool.Entry{ID: spool.NewID(past, test.name), Folder: "/in/batch", Name: test.name, Time: past, Status: spool.StatusPending}
+		if err := fs.services.Spool.Hold("mika", spool.HeldFile{Entry: entry}); err != nil {
+			t.Fatal(err)
+		}
+		fs.services.expireHeld("mika", entry, test.timeout)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !held("slow.csv") {
+		t.Error("file held at the clock's time expired right away")
+	}
+	if held("quick.csv") {
+		t.Error("file not expired after its timeout")
+	}
+}
+
+func TestBatchWithoutSpool(t *testing.T) {
+	var delivered []string
+	status := http.StatusOK
+	fs := batchFileSystem(t, &status, &delivered)
+	fs.services.Spool = nil
+	folder := &inbound.Folder{
+		Path:  "/in/batch",
+		Batch: &inbound.BatchPolicy{Triggers: []string{".done"}, Endpoint: "/api/futur/order/batch"},
+	}
+
+	w := &incomingWriterAt{username: "mika", filename: "order1.csv", folder: folder, fs: fs, data: []byte("data")}
+	if err := w.holdForBatch(w.data, nil); err == nil || w.held {
+		t.Errorf("holdForBatch without a spool = %v, held %v", err, w.held)
+	}
+	if err := fs.commitBatch(folder, "order1.done", nil); err == nil {
+		t.Error("commitBatch without a spool succeeded")
+	}
+	if err := fs.services.restoreHeld(); err != nil {
+		t.Errorf("restoreHeld without a spool = %v", err)
+	}
+	if len(delivered) != 0 {
+		t.Errorf("delivered %v", delivered)
+	}
+}
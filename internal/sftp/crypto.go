@@ -0,0 +1,235 @@
+package sftp
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CryptoPolicy selects the algorithms offered to clients. The lists replace
+// the profile's algorithms of their kind; all empty keeps the library defaults.
+type CryptoPolicy struct {
+	Profile      string   // modern, compatible or fips-like
+	KeyExchanges []string // Key exchange algorithms in preference order
+	Ciphers      []string
+	MACs         []string
+	PublicKeys   []string // Host key signature algorithms, certificates follow the algorithm of their key
+	AuditProfile string   // Logs connections whose algorithms this profile would refuse
+}
+
+// cryptoProfiles are the named algorithm sets of CryptoPolicy.Profile
+var cryptoProfiles = map[string]ssh.Algorithms{
+	// Current OpenSSH clients: hybrid post-quantum or Curve25519 key exchange, AEAD ciphers only
+	"modern": {
+		KeyExchanges: []string{ssh.KeyExchangeMLKEM768X25519, ssh.KeyExchangeCurve25519},
+		Ciphers:      []string{ssh.CipherChaCha20Poly1305, ssh.CipherAES256GCM, ssh.CipherAES128GCM},
+		MACs:         []string{ssh.HMACSHA256ETM, ssh.HMACSHA512ETM},
+		HostKeys: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256},
+	},
+	// Everything the library considers secure plus what older clients still need
+	"compatible": {
+		KeyExchanges: append(ssh.SupportedAlgorithms().KeyExchanges, ssh.InsecureKeyExchangeDH14SHA1),
+		Ciphers:      append(ssh.SupportedAlgorithms().Ciphers, ssh.InsecureCipherAES128CBC),
+		MACs:         ssh.SupportedAlgorithms().MACs,
+		HostKeys: []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+	},
+	// Only NIST curves, finite field groups, AES and SHA-2, the algorithms FIPS 140 allows.
+	// This is not a validated FIPS mode.
+	"fips-like": {
+		KeyExchanges: []string{ssh.KeyExchangeECDHP256, ssh.KeyExchangeECDHP384, ssh.KeyExchangeECDHP521,
+			ssh.KeyExchangeDH16SHA512, ssh.KeyExchangeDH14SHA256},
+		Ciphers: []string{ssh.CipherAES256GCM, ssh.CipherAES128GCM, ssh.CipherAES256CTR, ssh.CipherAES192CTR,
+			ssh.CipherAES128CTR},
+		MACs: []string{ssh.HMACSHA256ETM, ssh.HMACSHA512ETM, ssh.HMACSHA256, ssh.HMACSHA512},
+		HostKeys: []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256},
+	},
+}
+
+// curve25519LibSSH is the pre-RFC name of curve25519-sha256, added by the library whenever that one is allowed
+const curve25519LibSSH = "curve25519-sha256@libssh.org"
+
+// certSuffix ends the names of the certificate variants of host key algorithms
+const certSuffix = "-cert-v01@openssh.com"
+
+// cryptoAlgorithms resolves a policy into the algorithms offered to clients
+func cryptoAlgorithms(policy CryptoPolicy) (ssh.Algorithms, error) {
+	var algorithms ssh.Algorithms
+	if policy.Profile != "" {
+		profile, err := cryptoProfile(policy.Profile)
+		if err != nil {
+			return algorithms, err
+		}
+		algorithms = profile
+	}
+
+	known := knownAlgorithms()
+	lists := []struct {
+		kind   string
+		list   []string
+		known  []string
+		target *[]string
+	}{
+		{"key exchange", policy.KeyExchanges, known.KeyExchanges, &algorithms.KeyExchanges},
+		{"cipher", policy.Ciphers, known.Ciphers, &algorithms.Ciphers},
+		{"MAC", policy.MACs, known.MACs, &algorithms.MACs},
+		{"public key algorithm", policy.PublicKeys, known.HostKeys, &algorithms.HostKeys},
+	}
+	for _, l := range lists {
+		if len(l.list) == 0 {
+			continue
+		}
+		for _, name := range l.list {
+			if !slices.Contains(l.known, name) {
+				return algorithms, fmt.Errorf("unknown %s %q, supported: %s", l.kind, name, strings.Join(l.known, ", "))
+			}
+		}
+		*l.target = l.list
+	}
+
+	if policy.AuditProfile != "" {
+		if _, err := cryptoProfile(policy.AuditProfile); err != nil {
+			return algorithms, fmt.Errorf("audit profile: %w", err)
+		}
+	}
+	return algorithms, nil
+}
+
+// cryptoProfile returns a copy of a named profile
+func cryptoProfile(name string) (ssh.Algorithms, error) {
+	profile, ok := cryptoProfiles[name]
+	if !ok {
+		names := make([]string, 0, len(cryptoProfiles))
+		for n := range cryptoProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return ssh.Algorithms{}, fmt.Errorf("unknown crypto profile %q, available: %s", name, strings.Join(names, ", "))
+	}
+	return ssh.Algorithms{
+		KeyExchanges: slices.Clone(profile.KeyExchanges),
+		Ciphers:      slices.Clone(profile.Ciphers),
+		MACs:         slices.Clone(profile.MACs),
+		HostKeys:     slices.Clone(profile.HostKeys),
+	}, nil
+}
+
+// knownAlgorithms lists every algorithm the library implements. Host key
+// algorithms are the plain signature algorithms without certificate variants.
+func knownAlgorithms() ssh.Algorithms {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	known := ssh.Algorithms{
+		KeyExchanges: append(append(supported.KeyExchanges, insecure.KeyExchanges...), curve25519LibSSH),
+		Ciphers:      append(supported.Ciphers, insecure.Ciphers...),
+		MACs:         append(supported.MACs, insecure.MACs...),
+	}
+	for _, name := range append(supported.HostKeys, insecure.HostKeys...) {
+		if !strings.HasSuffix(name, certSuffix) {
+			known.HostKeys = append(known.HostKeys, name)
+		}
+	}
+	return known
+}
+
+// restrictHostKeys limits each host key and certificate to the allowed
+// signature algorithms and leaves out keys that may sign with none of them
+func restrictHostKeys(signers []ssh.Signer, allowed []string, logf func(format string, args ...interface{})) ([]ssh.Signer, error) {
+	if len(allowed) == 0 {
+		return signers, nil
+	}
+
+	var restricted []ssh.Signer
+	for _, signer := range signers {
+		keyType := strings.TrimSuffix(signer.PublicKey().Type(), certSuffix)
+		var algorithms []string
+		for _, algorithm := range allowed {
+			if keyType == ssh.KeyAlgoRSA && (algorithm == ssh.KeyAlgoRSASHA256 || algorithm == ssh.KeyAlgoRSASHA512) || algorithm == keyType {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+
+		algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+		if len(algorithms) == 0 || !ok {
+			logf("Not offering host key %s %s, the crypto policy allows none of its algorithms",
+				signer.PublicKey().Type(), ssh.FingerprintSHA256(signer.PublicKey()))
+			continue
+		}
+		limited, err := ssh.NewSignerWithAlgorithms(algorithmSigner, algorithms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restrict host key %s: %w", signer.PublicKey().Type(), err)
+		}
+		restricted = append(restricted, limited)
+	}
+
+	if len(restricted) == 0 {
+		return nil, fmt.Errorf("no host key can sign with the allowed public key algorithms %s", strings.Join(allowed, ", "))
+	}
+	return restricted, nil
+}
+
+// logAlgorithms logs the algorithms negotiated for a connection, and those the audit profile would refuse
+func (s *Server) logAlgorithms(sshConn *ssh.ServerConn, username string) {
+	meta, ok := sshConn.Conn.(ssh.AlgorithmsConnMetadata)
+	if !ok {
+		return
+	}
+	algorithms := meta.Algorithms()
+	s.services.logf("Negotiated for %s (%s): %s", username, sshConn.ClientVersion(), describeAlgorithms(algorithms))
+
+	if s.auditProfile == "" {
+		return
+	}
+	if refused := auditAlgorithms(s.auditProfile, algorithms); len(refused) > 0 {
+		s.services.logf("Crypto audit: %s (%s) would be refused by the %s profile: %s",
+			username, sshConn.ClientVersion(), s.auditProfile, strings.Join(refused, ", "))
+	}
+}
+
+// describeAlgorithms formats the negotiated algorithms of a connection for the log
+func describeAlgorithms(a ssh.NegotiatedAlgorithms) string {
+	cipher, mac := a.Read.Cipher, a.Read.MAC
+	if a.Write.Cipher != a.Read.Cipher {
+		cipher += "/" + a.Write.Cipher
+	}
+	if a.Write.MAC != a.Read.MAC {
+		mac += "/" + a.Write.MAC
+	}
+	if mac == "" {
+		mac = "implicit"
+	}
+	return fmt.Sprintf("kex %s, host key %s, cipher %s, mac %s", a.KeyExchange, a.HostKey, cipher, mac)
+}
+
+// auditAlgorithms returns the negotiated algorithms that the named profile does not allow
+func auditAlgorithms(profileName string, a ssh.NegotiatedAlgorithms) []string {
+	profile, err := cryptoProfile(profileName)
+	if err != nil {
+		return nil
+	}
+
+	var refused []string
+	kex := a.KeyExchange
+	if kex == curve25519LibSSH {
+		kex = ssh.KeyExchangeCurve25519
+	}
+	if !slices.Contains(profile.KeyExchanges, kex) {
+		refused = append(refused, "kex "+a.KeyExchange)
+	}
+	if !slices.Contains(profile.HostKeys, strings.TrimSuffix(a.HostKey, certSuffix)) {
+		refused = append(refused, "host key "+a.HostKey)
+	}
+	for _, direction := range []ssh.DirectionAlgorithms{a.Read, a.Write} {
+		if !slices.Contains(profile.Ciphers, direction.Cipher) {
+			refused = append(refused, "cipher "+direction.Cipher)
+		}
+		if direction.MAC != "" && !slices.Contains(profile.MACs, direction.MAC) {
+			refused = append(refused, "mac "+direction.MAC)
+		}
+	}
+	return slices.Compact(refused)
+}
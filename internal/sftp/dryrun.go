@@ -0,0 +1,210 @@
+package sftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/pgp"
+	"sftp-service/internal/spool"
+	"sftp-service/internal/storage"
+)
+
+// reportSuffix is appended to the uploaded name for the dry-run report in /out
+const reportSuffix = ".report.txt"
+
+// dryRunWriterAt receives an upload to a dry-run folder. Closing it writes a
+// validation report to /out; the file is never delivered as an order.
+type dryRunWriterAt struct {
+	fs      *APIFileSystem
+	folder  *inbound.Folder
+	path    string
+	name    string
+	started time.Time
+	data    []byte
+	err     error
+}
+
+func (w *dryRunWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	needed := int(off) + len(p)
+	if w.folder.MaxSize > 0 && int64(needed) > w.folder.MaxSize {
+		w.err = fmt.Errorf("test file exceeds maximum size of %d bytes", w.folder.MaxSize)
+		return 0, w.err
+	}
+
+	if needed > len(w.data) {
+		newData := make([]byte, needed)
+		copy(newData, w.data)
+		w.data = newData
+	}
+
+	copy(w.data[off:], p)
+	return len(p), nil
+}
+
+// TransferError is called when the connection drops before the file is closed,
+// so that no report is written for a partial test upload
+func (w *dryRunWriterAt) TransferError(err error) {
+	if w.err == nil {
+		w.err = fmt.Errorf("upload interrupted: %w", err)
+	}
+}
+
+func (w *dryRunWriterAt) Close() error {
+	if w.err != nil {
+		w.fs.services.logf("Dry run of %s/%s not reported: %v", w.fs.username, w.name, w.err)
+		return w.err
+	}
+
+	if w.fs.services.Spool == nil {
+		return fmt.Errorf("failed to store validation report: no report storage configured")
+	}
+	report := w.fs.dryRunReport(w.folder, w.path, w.name, w.data, w.started)
+
+	stored, err := w.fs.services.Spool.AddReport(w.fs.username, w.name+reportSuffix, report, w.fs.services.now())
+	if err != nil {
+		w.fs.services.logf("Failed to store dry-run report for %s/%s: %v", w.fs.username, w.name, err)
+		return fmt.Errorf("failed to store validation report")
+	}
+
+	w.fs.services.logf("Dry run of %s/%s finished, report in /out/%s", w.fs.username, w.name, stored)
+	return nil
+}
+
+// dryRunReport runs an upload through the naming, normalization and validation
+// stages of the folder it is tested against and describes the outcome
+func (fs *APIFileSystem) dryRunReport(folder *inbound.Folder, filePath, name string, data []byte, started time.Time) []byte {
+	rules := fs.services.Folders.Rules(folder)
+	// The metadata hash covers the upload as received, as it does for deliveries
+	sum := sha256.Sum256(data)
+
+	var b strings.Builder
+	passed := true
+	step := func(label, result string, ok bool) {
+		if !ok {
+			passed = false
+		}
+		fmt.Fprintf(&b, "%-14s %s\n", label+":", result)
+	}
+
+	fmt.Fprintf(&b, "Validation report for %s\n\n", name)
+	fmt.Fprintf(&b, "%-14s %s\n", "Uploaded to:", filePath)
+	fmt.Fprintf(&b, "%-14s %s\n", "Rules of:", rules.Path)
+	fmt.Fprintf(&b, "%-14s %s\n", "Uploaded at:", started.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "%-14s %d bytes\n\n", "Size:", len(data))
+
+	if fs.decryptsUploads() && pgp.IsEncryptedName(name) {
+		plain, err := fs.decrypt(data)
+		if err != nil {
+			step("Decryption", "FAILED - "+err.Error(), false)
+		} else {
+			step("Decryption", "OK - signed by your key", true)
+			name = pgp.TrimEncryptedName(name)
+			data = plain
+		}
+	}
+
+	filename, err := rules.Naming.CleanName(name)
+	switch {
+	case err != nil:
+		step("File name", "FAILED - "+err.Error(), false)
+		filename = name
+	case filename != name:
+		step("File name", "OK - would be delivered as "+filename, true)
+	default:
+		step("File name", "OK", true)
+	}
+
+	if rules.ExtensionAllowed(filename) {
+		step("File type", "OK", true)
+	} else {
+		step("File type", fmt.Sprintf("FAILED - allowed: %s", strings.Join(rules.AllowedExtensions, ", ")), false)
+	}
+
+	if rules.MaxSize > 0 && int64(len(data)) > rules.MaxSize {
+		step("Size", fmt.Sprintf("FAILED - maximum is %d bytes", rules.MaxSize), false)
+	} else {
+		step("Size", "OK", true)
+	}
+
+	normalized, encoding, err := inbound.Normalize(fs.normalization(rules), data)
+	if err != nil {
+		step("Encoding", "FAILED - "+err.Error(), false)
+	} else {
+		step("Encoding", fmt.Sprintf("OK - %s, %s", encoding, inbound.DetectContentType(filename, normalized)), true)
+
+		if err := rules.Validate(filename, normalized); err != nil {
+			step("Content", "FAILED - "+err.Error(), false)
+		} else {
+			step("Content", "OK", true)
+		}
+	}
+
+	// Only files that pass the local checks are shown to the API's dry-run mode
+	switch {
+	case folder.DryRun.Endpoint == "":
+		step("API check", "skipped - no dry-run mode available", true)
+	case !passed:
+		step("API check", "skipped - fix the errors above first", true)
+	default:
+		var metadata *storage.UploadMetadata
+		if rules.EnvelopeVersion != 1 {
+			metadata = &storage.UploadMetadata{
+				UploadedAt:    started.UTC().Format(time.RFC3339),
+				SessionID:     fs.session.ID,
+				RemoteAddr:    fs.session.RemoteAddr,
+				ClientVersion: fs.session.ClientVersion,
+				SHA256:        hex.EncodeToString(sum[:]),
+				ContentType:   inbound.DetectContentType(filename, normalized),
+				Encoding:      encoding,
+				Path:          filePath,
+			}
+		}
+
+		answer, err := fs.services.api().DryRunFileToAPI(fs.apiURL, folder.DryRun.Endpoint, rules.Envelope, fs.username, fs.apiKey, filename, normalized, metadata)
+		if err != nil {
+			step("API check", "FAILED - "+err.Error(), false)
+		} else {
+			step("API check", "OK - "+strings.TrimSpace(answer), true)
+		}
+	}
+
+	if passed {
+		b.WriteString("\nResult: PASSED\n")
+	} else {
+		b.WriteString("\nResult: FAILED\n")
+	}
+	b.WriteString("This was a test upload. Nothing was forwarded as an order.\n")
+	return []byte(b.String())
+}
+
+// listReports returns the user's dry-run reports for the /out listing
+func (fs *APIFileSystem) listReports() []os.FileInfo {
+	if fs.services.Spool == nil {
+		return nil
+	}
+	files, err := fs.services.Spool.Files(spool.AreaReports, fs.username)
+	if err != nil {
+		fs.services.logf("Failed to list reports for %s: %v", fs.username, err)
+		return nil
+	}
+
+	var fileInfos []os.FileInfo
+	for _, file := range files {
+		fileInfos = append(fileInfos, spoolFileInfo(file))
+	}
+	return fileInfos
+}
+
+// isReport checks if a name in /out is one of the user's dry-run reports
+func (fs *APIFileSystem) isReport(name string) bool {
+	if !strings.HasSuffix(name, reportSuffix) || fs.services.Spool == nil {
+		return false
+	}
+	_, err := fs.services.Spool.StatFile(spool.AreaReports, fs.username, name)
+	return err == nil
+}
@@ -1,6 +1,9 @@
 package sftp
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,27 +12,92 @@ import (
 	"strings"
 	"time"
 
+	"sftp-service/internal/config"
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/pgp"
+	"sftp-service/internal/pricelist"
+	"sftp-service/internal/quota"
+	"sftp-service/internal/spool"
 	"sftp-service/internal/storage"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/pkg/sftp"
 )
 
+// Session describes the SSH connection behind an SFTP session
+type Session struct {
+	ID            string // Hex encoded SSH session identifier
+	RemoteAddr    string
+	ClientVersion string
+}
+
 // APIFileSystem implements sftp.FileLister, sftp.FileReader, sftp.FileWriter, sftp.FileCmder, and sftp.FileStater interfaces
 type APIFileSystem struct {
 	apiURL      string // API base URL for both pricelist and incoming orders
 	username    string
-	apiKey      string   // API key for authenticated calls
-	allowedDirs []string // Allowed directories for this user
-	allowedOps  []string // Allowed operations
+	apiKey      string                // API key for authenticated calls
+	session     Session               // SSH connection this file system serves
+	allowedDirs []string              // Allowed directories for this user
+	allowedOps  []string              // Allowed operations
+	settings    config.UserSettings   // Per-user overrides, quota already merged with the login response
+	services    *Services             // Server-wide components shared by all sessions
+	names       *inbound.NameRegistry // Names uploaded in this session
+}
+
+// Services are the server-wide components shared by all file systems
+type Services struct {
+	Folders *inbound.Router       // Inbound folders under /in and their API endpoints
+	Audit   *inbound.Archive      // Keeps original uploads, nil when disabled
+	Quotas  *quota.Tracker        // Upload counts for quota enforcement
+	Names   *inbound.NameRegistry // Recently uploaded names, for collision windows
+	Spool   *spool.Spool          // Journal of uploads and their delivery status, nil disables it along with rejected files, dry-run reports and batches
+
+	PGPKey     openpgp.EntityList // Service private key for encrypted uploads, nil when disabled
+	Pricelists *pricelist.Cache   // Recently downloaded pricelist archives per user
+
+	PricelistVersions *pricelist.Store   // Full pricelist versions and what each user last downloaded
+	PricelistFormats  *pricelist.Formats // Default derived pricelist files
+
+	ListingWindow time.Duration // How far back uploads are listed in /in, zero disables
+	ListingStatus string        // How the status is shown, ListingStatusSuffix or ListingStatusFiles
+
+	Logger *log.Logger      // Log output, the standard logger when nil
+	Clock  func() time.Time // Current time, time.Now when nil
+}
+
+// logf logs through the configured logger
+func (s *Services) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// now returns the current time from the configured clock
+func (s *Services) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// api returns a web API client that logs and stamps requests like the server
+func (s *Services) api() *storage.Client {
+	return &storage.Client{Logger: s.Logger, Clock: s.Clock}
 }
 
 // NewAPIFileSystem creates a new API-backed file system with restricted access
-func NewAPIFileSystem(apiURL, username, apiKey string) *APIFileSystem {
+func NewAPIFileSystem(apiURL, username, apiKey string, session Session, settings config.UserSettings, services *Services) *APIFileSystem {
 	return &APIFileSystem{
 		apiURL:      apiURL,
 		username:    username,
 		apiKey:      apiKey,
-		allowedDirs: []string{"/", "/in", "/Hinnat"},           // Only root, in, and Hinnat directories
+		session:     session,
+		settings:    settings,
+		services:    services,
+		names:       inbound.NewNameRegistry(),
+		allowedDirs: []string{"/", "/in", "/out", "/Hinnat"},   // Only root, in, out and Hinnat directories
 		allowedOps:  []string{"list", "read", "write-in-only"}, // List and read everywhere, write only to /in
 	}
 }
@@ -62,14 +130,96 @@ func (fs *APIFileSystem) isWriteAllowed(path string) bool {
 	return strings.HasPrefix(path, "/in/") || path == "/in"
 }
 
-// isInIncomingDirectory checks if path is in /in/ directory
+// isInIncomingDirectory checks if path is anywhere below /in/
 func (fs *APIFileSystem) isInIncomingDirectory(path string) bool {
-	return strings.HasPrefix(path, "/in/") && !strings.Contains(strings.TrimPrefix(path, "/in/"), "/")
+	return strings.HasPrefix(path, "/in/")
+}
+
+// isIncomingFolder checks if path is /in itself or one of the configured inbound folders
+func (fs *APIFileSystem) isIncomingFolder(path string) bool {
+	return path == "/in" || fs.services.Folders.IsFolder(path)
+}
+
+// isInOutgoingDirectory checks if path is a document in /out/ directory
+func (fs *APIFileSystem) isInOutgoingDirectory(path string) bool {
+	return strings.HasPrefix(path, "/out/") && !strings.Contains(strings.TrimPrefix(path, "/out/"), "/")
+}
+
+// normalization returns the normalization settings for uploads to folder with the user's settings applied on top
+func (fs *APIFileSystem) normalization(folder *inbound.Folder) *inbound.Normalization {
+	return folder.Normalize.Merge(fs.settings.Normalize)
+}
+
+// claimName handles name collisions according to the folder's policy. It returns
+// the registry holding the reservation (nil if none was made) and the name to deliver under.
+func (fs *APIFileSystem) claimName(folder *inbound.Folder, filename string) (*inbound.NameRegistry, string, error) {
+	policy := folder.Naming
+	if policy == nil || policy.Collision == inbound.CollisionAllow {
+		return nil, filename, nil
+	}
+
+	now := fs.services.now()
+	registry := fs.names
+	var expires time.Time
+	if window := time.Duration(policy.CollisionWindow); window > 0 {
+		registry = fs.services.Names
+		expires = now.Add(window)
+	}
+
+	resolved, err := registry.Claim(fs.username, folder.Path, filename, policy, now, expires)
+	if err != nil {
+		return nil, "", err
+	}
+	return registry, resolved, nil
+}
+
+// userLimits returns the user-wide upload quota
+func (fs *APIFileSystem) userLimits() quota.Limits {
+	if fs.settings.Quota != nil {
+		return *fs.settings.Quota
+	}
+	return quota.Limits{}
+}
+
+// folderLimits returns the per-folder upload quota, including the folder's size limit
+func (fs *APIFileSystem) folderLimits(folder *inbound.Folder) quota.Limits {
+	var limits quota.Limits
+	if folder.Quota != nil {
+		limits = *folder.Quota
+	}
+	if folder.MaxSize > 0 && (limits.MaxFileSize == 0 || folder.MaxSize < limits.MaxFileSize) {
+		limits.MaxFileSize = folder.MaxSize
+	}
+	return limits
+}
+
+// checkQuotaOpen checks the user and folder quotas before a new upload starts
+func (fs *APIFileSystem) checkQuotaOpen(folder *inbound.Folder) error {
+	quotas := fs.services.Quotas
+	if err := quotas.CheckOpen(quota.Key(fs.username, ""), "user "+fs.username, fs.userLimits()); err != nil {
+		return err
+	}
+	return quotas.CheckOpen(quota.Key(fs.username, folder.Path), folder.Path, fs.folderLimits(folder))
+}
+
+// checkQuotaSize checks the user and folder quotas while an upload grows
+func (fs *APIFileSystem) checkQuotaSize(folder *inbound.Folder, size int64) error {
+	quotas := fs.services.Quotas
+	if err := quotas.CheckSize(quota.Key(fs.username, ""), "user "+fs.username, fs.userLimits(), size); err != nil {
+		return err
+	}
+	return quotas.CheckSize(quota.Key(fs.username, folder.Path), folder.Path, fs.folderLimits(folder), size)
+}
+
+// recordUpload counts an upload the API accepted against the quotas
+func (fs *APIFileSystem) recordUpload(folder *inbound.Folder, size int64) {
+	fs.services.Quotas.Record(quota.Key(fs.username, ""), size)
+	fs.services.Quotas.Record(quota.Key(fs.username, folder.Path), size)
 }
 
 // Realpath resolves absolute paths for SFTP operations
 func (fs *APIFileSystem) Realpath(path string) string {
-	log.Printf("Realpath: %s", path)
+	fs.services.logf("Realpath: %s", path)
 
 	// Normalize path
 	if path == "" || path == "." {
@@ -79,52 +229,174 @@ func (fs *APIFileSystem) Realpath(path string) string {
 		path = "/" + path
 	}
 
-	log.Printf("Realpath resolved to: '%s'", path)
+	fs.services.logf("Realpath resolved to: '%s'", path)
 	return path
 }
 
 // Fileread implements sftp.FileReader
 func (fs *APIFileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
-	log.Printf("Reading file: %s (user: %s)", r.Filepath, fs.username)
+	fs.services.logf("Reading file: %s (user: %s)", r.Filepath, fs.username)
 
 	// Check if path is allowed
 	if !fs.isPathAllowed(r.Filepath) {
-		log.Printf("Access denied: user %s tried to read %s", fs.username, r.Filepath)
+		fs.services.logf("Access denied: user %s tried to read %s", fs.username, r.Filepath)
 		return nil, fmt.Errorf("access denied: path not allowed")
 	}
 
+	// Files in the dead-letter folder can be read back to see what was rejected
+	if isRejectedPath(r.Filepath) && fs.services.Spool != nil {
+		data, err := fs.services.Spool.ReadFile(spool.AreaRejected, fs.username, filepath.Base(r.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		return &bytesReaderAt{data: data}, nil
+	}
+
+	// Status files of recent uploads are the only other readable files in /in/
+	if fs.isInIncomingDirectory(r.Filepath) {
+		if entry, isStatus, ok := fs.findUpload(r.Filepath); ok && isStatus {
+			return &bytesReaderAt{data: uploadStatusText(entry)}, nil
+		}
+	}
+
 	// Deny reading from /in/ directory (write-only)
 	if fs.isInIncomingDirectory(r.Filepath) {
-		log.Printf("Read denied from /in/: user %s tried to read %s", fs.username, r.Filepath)
+		fs.services.logf("Read denied from /in/: user %s tried to read %s", fs.username, r.Filepath)
 		return nil, fmt.Errorf("access denied: /in/ directory is write-only")
 	}
 
-	data, err := storage.DownloadPricelist(fs.apiURL, fs.username, fs.apiKey, r.Filepath)
+	// Dry-run reports are kept by the service itself
+	if fs.isInOutgoingDirectory(r.Filepath) && fs.isReport(filepath.Base(r.Filepath)) {
+		data, err := fs.services.Spool.ReadFile(spool.AreaReports, fs.username, filepath.Base(r.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		return &bytesReaderAt{data: data}, nil
+	}
+
+	// Stream documents from /out/ directly from the API
+	if fs.isInOutgoingDirectory(r.Filepath) {
+		name := filepath.Base(r.Filepath)
+		return &streamReaderAt{
+			open: func(offset int64) (io.ReadCloser, error) {
+				return fs.services.api().OpenDocument(fs.apiURL, fs.username, fs.apiKey, name, offset)
+			},
+		}, nil
+	}
+
+	// Pricelists can be served encrypted to the customer's PGP key
+	if plainPath, ok := fs.encryptedDownload(r.Filepath); ok {
+		data, version, err := fs.readEncryptedPricelist(plainPath)
+		if err != nil {
+			return nil, err
+		}
+		return fs.pricelistReader(data, version), nil
+	}
+
+	data, version, err := fs.readPricelistFile(r.Filepath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &bytesReaderAt{data: data}, nil
+	return fs.pricelistReader(data, version), nil
 }
 
 // Filewrite implements sftp.FileWriter
 func (fs *APIFileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
-	log.Printf("SFTP Write %s: %s (user: %s)", r.Method, r.Filepath, fs.username)
+	fs.services.logf("SFTP Write %s: %s (user: %s)", r.Method, r.Filepath, fs.username)
 
 	// Check if path is allowed for writing
 	if !fs.isPathAllowed(r.Filepath) || !fs.isWriteAllowed(r.Filepath) {
-		log.Printf("Write access denied: user %s tried to write to %s", fs.username, r.Filepath)
+		fs.services.logf("Write access denied: user %s tried to write to %s", fs.username, r.Filepath)
 		return nil, fmt.Errorf("access denied: write not allowed to this path")
 	}
 
-	// Handle /in/ directory separately (file storage)
+	// Handle /in/ directories separately, each routed to its own API endpoint
 	if fs.isInIncomingDirectory(r.Filepath) {
-		filename := filepath.Base(r.Filepath)
+		folder, ok := fs.services.Folders.FolderFor(r.Filepath)
+		if !ok {
+			fs.services.logf("Write denied: user %s tried to write outside inbound folders: %s", fs.username, r.Filepath)
+			return nil, fmt.Errorf("access denied: %s is not an upload folder", filepath.Dir(r.Filepath))
+		}
+
+		// Test uploads are only validated and reported, never delivered
+		if folder.DryRun != nil {
+			if fs.services.Spool == nil {
+				fs.services.logf("Dry run refused: no spool for reports (user: %s, folder: %s)", fs.username, folder.Path)
+				return nil, fmt.Errorf("test uploads are not available: no report storage configured")
+			}
+			return &dryRunWriterAt{
+				fs:      fs,
+				folder:  folder,
+				path:    r.Filepath,
+				name:    filepath.Base(r.Filepath),
+				started: fs.services.now(),
+			}, nil
+		}
+
+		// Batch uploads are held in the spool until their batch is committed
+		if folder.Batch != nil && fs.services.Spool == nil {
+			fs.services.logf("Batch upload refused: no spool to hold files (user: %s, folder: %s)", fs.username, folder.Path)
+			return nil, fmt.Errorf("batch uploads are not available: no spool configured")
+		}
+
+		// Trigger and manifest files commit a batch instead of being delivered themselves
+		if folder.Batch.IsControlFile(filepath.Base(r.Filepath)) {
+			return &batchTriggerWriterAt{
+				fs:     fs,
+				folder: folder,
+				name:   filepath.Base(r.Filepath),
+			}, nil
+		}
+
+		// Encrypted uploads are checked and delivered under their decrypted name
+		name := filepath.Base(r.Filepath)
+		encrypted := fs.decryptsUploads() && pgp.IsEncryptedName(name)
+		if encrypted {
+			name = pgp.TrimEncryptedName(name)
+		}
+
+		// Apply the folder's file name policy before anything else
+		filename, err := folder.Naming.CleanName(name)
+		if err != nil {
+			fs.services.logf("Write denied: user %s: %v", fs.username, err)
+			return nil, err
+		}
+
+		if !folder.ExtensionAllowed(filename) {
+			fs.services.logf("Write denied: user %s uploaded %s with a disallowed extension", fs.username, r.Filepath)
+			return nil, fmt.Errorf("file type not allowed in %s (allowed: %s)", folder.Path, strings.Join(folder.AllowedExtensions, ", "))
+		}
+
+		// Refuse the upload before any data is buffered if a quota is already used up
+		if err := fs.checkQuotaOpen(folder); err != nil {
+			fs.services.logf("Write denied: user %s: %v", fs.username, err)
+			return nil, err
+		}
+
+		registry, filename, err := fs.claimName(folder, filename)
+		if err != nil {
+			fs.services.logf("Write denied: user %s: %v", fs.username, err)
+			return nil, err
+		}
+		if filename != filepath.Base(r.Filepath) {
+			fs.services.logf("Upload %s from user %s will be delivered as %s", r.Filepath, fs.username, filename)
+		}
+
+		now := fs.services.now()
 		return &incomingWriterAt{
-			apiURL:   fs.apiURL,
-			username: fs.username,
-			apiKey:   fs.apiKey,
-			filename: filename,
+			apiURL:    fs.apiURL,
+			username:  fs.username,
+			apiKey:    fs.apiKey,
+			id:        spool.NewID(now, filename),
+			started:   now,
+			filename:  filename,
+			original:  filepath.Base(r.Filepath),
+			path:      r.Filepath,
+			folder:    folder,
+			encrypted: encrypted,
+			fs:        fs,
+			registry:  registry,
 		}, nil
 	}
 
@@ -134,48 +406,174 @@ func (fs *APIFileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
 
 // Filecmd implements sftp.FileCmder
 func (fs *APIFileSystem) Filecmd(r *sftp.Request) error {
-	log.Printf("SFTP command: %s %s (user: %s)", r.Method, r.Filepath, fs.username)
+	fs.services.logf("SFTP command: %s %s (user: %s)", r.Method, r.Filepath, fs.username)
 
 	// Check if path is allowed
 	if !fs.isPathAllowed(r.Filepath) {
-		log.Printf("Command access denied: user %s tried %s on %s", fs.username, r.Method, r.Filepath)
+		fs.services.logf("Command access denied: user %s tried %s on %s", fs.username, r.Method, r.Filepath)
 		return fmt.Errorf("access denied: path not allowed")
 	}
 
 	switch r.Method {
 	case "Remove":
-		// Deny all delete operations
-		log.Printf("Delete denied: user %s tried to delete %s", fs.username, r.Filepath)
+		// Dry-run reports are simply deleted
+		if fs.isInOutgoingDirectory(r.Filepath) && fs.isReport(filepath.Base(r.Filepath)) {
+			return fs.services.Spool.RemoveFile(spool.AreaReports, fs.username, filepath.Base(r.Filepath))
+		}
+
+		// Removing a document from /out/ acknowledges it to the API
+		if fs.isInOutgoingDirectory(r.Filepath) {
+			return fs.services.api().AcknowledgeDocument(fs.apiURL, fs.username, fs.apiKey, filepath.Base(r.Filepath))
+		}
+
+		// Handled entries can be removed from the dead-letter folder
+		if isRejectedPath(r.Filepath) && fs.services.Spool != nil {
+			fs.services.logf("Removing rejected file %s (user: %s)", r.Filepath, fs.username)
+			return fs.services.Spool.RemoveFile(spool.AreaRejected, fs.username, filepath.Base(r.Filepath))
+		}
+
+		// Deny all other delete operations
+		fs.services.logf("Delete denied: user %s tried to delete %s", fs.username, r.Filepath)
 		return fmt.Errorf("access denied: delete operations not allowed")
 	case "Mkdir":
-		log.Printf("Mkdir denied: user %s tried to delete %s", fs.username, r.Filepath)
+		fs.services.logf("Mkdir denied: user %s tried to delete %s", fs.username, r.Filepath)
 		return fmt.Errorf("access denied: mkdir operations not allowed")
 	case "Rename":
 		// Deny all rename operations
-		log.Printf("Rename denied: user %s tried to delete %s", fs.username, r.Filepath)
+		fs.services.logf("Rename denied: user %s tried to delete %s", fs.username, r.Filepath)
 		return fmt.Errorf("access denied: rename operations not allowed")
 	case "Rmdir":
 		// Deny all directory removal operations
-		log.Printf("Rmdir denied: user %s tried to remove directory %s", fs.username, r.Filepath)
+		fs.services.logf("Rmdir denied: user %s tried to remove directory %s", fs.username, r.Filepath)
 		return fmt.Errorf("access denied: directory removal not allowed")
 	default:
 		return sftp.ErrSSHFxOpUnsupported
 	}
 }
 
+// statvfs flag bits (see statvfs(3))
+const (
+	statvfsReadOnly = 0x1
+	statvfsNoSUID   = 0x2
+)
+
+// statvfsBlockSize is the block size reported to statvfs@openssh.com clients
+const statvfsBlockSize = 4096
+
+// statvfsUnlimited is reported as free space when no quota applies (1 TiB)
+const statvfsUnlimited = 1 << 40
+
+// StatVFS implements sftp.StatVFSFileCmder, reporting the remaining upload quota as free space
+func (fs *APIFileSystem) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+	fs.services.logf("SFTP StatVFS: %s (user: %s)", r.Filepath, fs.username)
+
+	if !fs.isPathAllowed(r.Filepath) {
+		fs.services.logf("Access denied: user %s tried StatVFS on %s", fs.username, r.Filepath)
+		return nil, fmt.Errorf("access denied: path not allowed")
+	}
+
+	stat := &sftp.StatVFS{
+		Bsize:   statvfsBlockSize,
+		Frsize:  statvfsBlockSize,
+		Flag:    statvfsNoSUID,
+		Namemax: 255,
+	}
+
+	// Only inbound folders accept uploads, everything else is reported read-only and full
+	folder, ok := fs.services.Folders.Folder(r.Filepath)
+	if !ok {
+		folder, ok = fs.services.Folders.FolderFor(r.Filepath)
+	}
+	if !ok {
+		stat.Flag |= statvfsReadOnly
+		return stat, nil
+	}
+
+	if folder.Naming != nil && folder.Naming.MaxLength > 0 {
+		stat.Namemax = uint64(folder.Naming.MaxLength)
+	}
+
+	userLimits := fs.userLimits()
+	folderLimits := fs.folderLimits(folder)
+	userUsage := fs.services.Quotas.Usage(quota.Key(fs.username, ""))
+	folderUsage := fs.services.Quotas.Usage(quota.Key(fs.username, folder.Path))
+
+	// Total is the daily byte quota, free is what is left of it today
+	total := minLimit(limit(userLimits.BytesPerDay), limit(folderLimits.BytesPerDay))
+	free := minLimit(userLimits.RemainingBytes(userUsage), folderLimits.RemainingBytes(folderUsage))
+
+	// A single upload can never be larger than the maximum file size
+	free = minLimit(free, minLimit(limit(userLimits.MaxFileSize), limit(folderLimits.MaxFileSize)))
+	if total < 0 {
+		total = max(free, statvfsUnlimited)
+	}
+	if free < 0 {
+		free = statvfsUnlimited
+	}
+
+	stat.Blocks = uint64(total) / statvfsBlockSize
+	stat.Bfree = uint64(free) / statvfsBlockSize
+	stat.Bavail = stat.Bfree
+
+	files := minLimit(int64(userLimits.RemainingFiles(userUsage)), int64(folderLimits.RemainingFiles(folderUsage)))
+	if files < 0 {
+		files = statvfsUnlimited / statvfsBlockSize
+	}
+	stat.Files = uint64(files)
+	stat.Ffree = uint64(files)
+	stat.Favail = uint64(files)
+
+	return stat, nil
+}
+
+// minLimit returns the smaller of two limits where a negative value means unlimited
+func minLimit(a, b int64) int64 {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// limit converts a quota setting, where zero means unlimited, for minLimit
+func limit(value int64) int64 {
+	if value > 0 {
+		return value
+	}
+	return -1
+}
+
 // Filelist implements sftp.FileLister
 func (fs *APIFileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
-	log.Printf("SFTP %s: %s (user: %s)", r.Method, r.Filepath, fs.username)
+	lister, err := fs.filelist(r)
+	if err != nil || !strings.HasPrefix(r.Filepath, "/Hinnat") {
+		return lister, err
+	}
+
+	dir := r.Filepath
+	if r.Method != "List" {
+		dir = filepath.Dir(r.Filepath)
+	}
+	if l, ok := lister.(*listerat); ok {
+		fs.hideEncryptedSizes(dir, l.files)
+	}
+	return lister, nil
+}
+
+func (fs *APIFileSystem) filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	fs.services.logf("SFTP %s: %s (user: %s)", r.Method, r.Filepath, fs.username)
 
 	// Log warning for unsupported Readlink operations
 	if r.Method == "Readlink" {
-		log.Printf("WARNING: FileLister method 'Readlink' not supported for %s (symbolic links not supported)", r.Filepath)
+		fs.services.logf("WARNING: FileLister method 'Readlink' not supported for %s (symbolic links not supported)", r.Filepath)
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 
 	// Check if path is allowed
 	if !fs.isPathAllowed(r.Filepath) {
-		log.Printf("Access denied: user %s tried %s on %s", fs.username, r.Method, r.Filepath)
+		fs.services.logf("Access denied: user %s tried %s on %s", fs.username, r.Method, r.Filepath)
 		return nil, fmt.Errorf("access denied: path not allowed")
 	}
 
@@ -184,17 +582,44 @@ func (fs *APIFileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
 		return fs.listRootDirectory()
 	}
 
-	// Handle /in/ directory specially (PostgreSQL storage)
-	if r.Filepath == "/in" {
+	// Handle /in/ and the inbound folders below it
+	if fs.isIncomingFolder(r.Filepath) {
+		if r.Method == "Stat" {
+			// Return directory info for stat request (cd command)
+			return fs.statInDirectory(r.Filepath)
+		} else {
+			// List files inside directory for ls command
+			return fs.listInDirectory(r.Filepath)
+		}
+	}
+
+	// Handle the dead-letter folder and the rejected files in it
+	if r.Filepath == rejectedDir {
+		if r.Method == "Stat" && fs.services.Spool != nil {
+			return fs.statInDirectory(r.Filepath)
+		}
+		return fs.listRejectedDirectory()
+	}
+	if isRejectedPath(r.Filepath) {
+		return fs.statRejectedFile(filepath.Base(r.Filepath))
+	}
+
+	// Handle /out directory (documents from the API)
+	if r.Filepath == "/out" {
 		if r.Method == "Stat" {
 			// Return directory info for stat request (cd command)
-			return fs.statInDirectory()
+			return fs.statOutDirectory()
 		} else {
 			// List files inside directory for ls command
-			return fs.listInDirectory()
+			return fs.listOutDirectory()
 		}
 	}
 
+	// Handle single documents in /out
+	if fs.isInOutgoingDirectory(r.Filepath) {
+		return fs.statOutDocument(filepath.Base(r.Filepath))
+	}
+
 	// Handle /Hinnat directory
 	if r.Filepath == "/Hinnat" {
 		if r.Method == "Stat" {
@@ -206,17 +631,47 @@ func (fs *APIFileSystem) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
 		}
 	}
 
+	// Handle the generated delta archive
+	if r.Filepath == "/Hinnat/"+pricelist.DeltaName {
+		if fileInfo, ok := fs.deltaFileInfo(); ok {
+			return &listerat{files: []os.FileInfo{fileInfo}}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	// Handle the dated pricelist versions
+	if r.Filepath == historyDir || strings.HasPrefix(r.Filepath, historyDir+"/") {
+		return fs.listHistoryDir(r.Method, r.Filepath)
+	}
+
+	// Handle the pricelist files converted to other formats
+	if filepath.Dir(r.Filepath) == "/Hinnat" && fs.pricelistFormats().IsConverted(filepath.Base(r.Filepath)) {
+		return &listerat{files: []os.FileInfo{fs.convertedFileInfo(filepath.Base(r.Filepath))}}, nil
+	}
+
+	// Handle the pricelist archive's entries
+	if isInArchiveDir(r.Filepath) {
+		return fs.listArchiveDir(r.Method, r.Filepath)
+	}
+
 	// Handle /Hinnat/salhydro_kaikki.zip file specifically
-	if r.Filepath == "/Hinnat/salhydro_kaikki.zip" {
+	if r.Filepath == "/Hinnat/salhydro_kaikki.zip" || (r.Filepath == "/Hinnat/salhydro_kaikki.zip"+pgp.EncryptedSuffix && fs.pgpDownloads() == pgp.DownloadsSuffix) {
 		fileInfo := &apiFileInfo{
-			name:    "salhydro_kaikki.zip",
-			size:    2 * 1024 * 1024,
-			modTime: time.Now(),
+			name:    filepath.Base(r.Filepath),
+			size:    fs.archiveSize(),
+			modTime: fs.services.now(),
 			isDir:   false,
 		}
 		return &listerat{files: []os.FileInfo{fileInfo}}, nil
 	}
 
+	// Handle recent uploads (and their status files) listed in /in
+	if fs.isInIncomingDirectory(r.Filepath) {
+		if fileInfo, ok := fs.uploadFileInfo(r.Filepath); ok {
+			return &listerat{files: []os.FileInfo{fileInfo}}, nil
+		}
+	}
+
 	// If no specific handler found, return empty file list
 	var fileInfos []os.FileInfo
 	return &listerat{files: fileInfos}, nil
@@ -227,11 +682,46 @@ func (fs *APIFileSystem) listHinnatDirectory() (sftp.ListerAt, error) {
 	var fileInfos []os.FileInfo
 	fileInfos = append(fileInfos, &apiFileInfo{
 		name:    "salhydro_kaikki.zip",
-		size:    2 * 1024 * 1024, // 2MB
-		modTime: time.Now(),
+		size:    fs.archiveSize(),
+		modTime: fs.services.now(),
 		isDir:   false,
 	})
 
+	// The archive's entries, downloadable one by one
+	fileInfos = append(fileInfos, &apiFileInfo{
+		name:    filepath.Base(archiveDir),
+		size:    0,
+		modTime: fs.services.now(),
+		isDir:   true,
+	})
+
+	// Dated versions of the archive
+	fileInfos = append(fileInfos, &apiFileInfo{
+		name:    filepath.Base(historyDir),
+		size:    0,
+		modTime: fs.services.now(),
+		isDir:   true,
+	})
+
+	// The archive converted to the user's formats
+	for _, name := range fs.pricelistFormats().Names() {
+		fileInfos = append(fileInfos, fs.convertedFileInfo(name))
+	}
+
+	// Changes since the user's last full download
+	if fileInfo, ok := fs.deltaFileInfo(); ok {
+		fileInfos = append(fileInfos, fileInfo)
+	}
+
+	// Encrypted copy for customers with a PGP key
+	if fs.pgpDownloads() == pgp.DownloadsSuffix {
+		fileInfos = append(fileInfos, &apiFileInfo{
+			name:    "salhydro_kaikki.zip" + pgp.EncryptedSuffix,
+			modTime: fs.services.now(),
+			isDir:   false,
+		})
+	}
+
 	return &listerat{files: fileInfos}, nil
 }
 
@@ -240,26 +730,107 @@ func (fs *APIFileSystem) statHinnatDirectory() (sftp.ListerAt, error) {
 	fileInfo := &apiFileInfo{
 		name:    "Hinnat",
 		size:    0,
-		modTime: time.Now(),
+		modTime: fs.services.now(),
 		isDir:   true,
 	}
 
 	return &listerat{files: []os.FileInfo{fileInfo}}, nil
 }
 
-// listInDirectory returns an empty directory (files are processed immediately on upload)
-func (fs *APIFileSystem) listInDirectory() (sftp.ListerAt, error) {
-	// Return empty directory - files are sent to API immediately when uploaded
+// listInDirectory returns the inbound subfolders of dir and the user's recent uploads to it
+func (fs *APIFileSystem) listInDirectory(dir string) (sftp.ListerAt, error) {
+	// Files are sent to API immediately when uploaded, only their status is listed
+	fileInfos := fs.uploadFileInfos(dir)
+	for _, name := range fs.services.Folders.Subfolders(dir) {
+		fileInfos = append(fileInfos, &apiFileInfo{
+			name:    name,
+			size:    0,
+			modTime: fs.services.now(),
+			isDir:   true,
+		})
+	}
+
+	// Uploads refused by the API are kept in a hidden folder below /in
+	if dir == "/in" && fs.services.Spool != nil {
+		fileInfos = append(fileInfos, &apiFileInfo{
+			name:    filepath.Base(rejectedDir),
+			size:    0,
+			modTime: fs.services.now(),
+			isDir:   true,
+		})
+	}
+	return &listerat{files: fileInfos}, nil
+}
+
+// statInDirectory returns directory info for /in or an inbound folder (for cd command)
+func (fs *APIFileSystem) statInDirectory(dir string) (sftp.ListerAt, error) {
+	fileInfo := &apiFileInfo{
+		name:    filepath.Base(dir),
+		size:    0,
+		modTime: fs.services.now(),
+		isDir:   true,
+	}
+
+	return &listerat{files: []os.FileInfo{fileInfo}}, nil
+}
+
+// listOutDirectory returns the documents the API has addressed to the user
+func (fs *APIFileSystem) listOutDirectory() (sftp.ListerAt, error) {
+	documents, err := fs.services.api().ListDocuments(fs.apiURL, fs.username, fs.apiKey)
+	if err != nil {
+		fs.services.logf("Failed to list documents for user %s: %v", fs.username, err)
+		return nil, fmt.Errorf("failed to list documents")
+	}
+
 	var fileInfos []os.FileInfo
+	for _, doc := range documents {
+		fileInfos = append(fileInfos, &apiFileInfo{
+			name:    doc.Name,
+			size:    doc.Size,
+			modTime: doc.LastModified,
+			isDir:   false,
+		})
+	}
+	fileInfos = append(fileInfos, fs.listReports()...)
+
 	return &listerat{files: fileInfos}, nil
 }
 
-// statInDirectory returns directory info for /in (for cd command)
-func (fs *APIFileSystem) statInDirectory() (sftp.ListerAt, error) {
+// statOutDocument returns file info for a single document in /out
+func (fs *APIFileSystem) statOutDocument(name string) (sftp.ListerAt, error) {
+	if fs.services.Spool != nil {
+		if file, err := fs.services.Spool.StatFile(spool.AreaReports, fs.username, name); err == nil {
+			return &listerat{files: []os.FileInfo{spoolFileInfo(file)}}, nil
+		}
+	}
+
+	documents, err := fs.services.api().ListDocuments(fs.apiURL, fs.username, fs.apiKey)
+	if err != nil {
+		fs.services.logf("Failed to list documents for user %s: %v", fs.username, err)
+		return nil, fmt.Errorf("failed to list documents")
+	}
+
+	for _, doc := range documents {
+		if doc.Name == name {
+			fileInfo := &apiFileInfo{
+				name:    doc.Name,
+				size:    doc.Size,
+				modTime: doc.LastModified,
+				isDir:   false,
+			}
+			return &listerat{files: []os.FileInfo{fileInfo}}, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// statOutDirectory returns directory info for /out (for cd command)
+func (fs *APIFileSystem) statOutDirectory() (sftp.ListerAt, error) {
 	fileInfo := &apiFileInfo{
-		name:    "in",
+		name:    "out",
 		size:    0,
-		modTime: time.Now(),
+		modTime: fs.services.now(),
 		isDir:   true,
 	}
 
@@ -274,14 +845,21 @@ func (fs *APIFileSystem) listRootDirectory() (sftp.ListerAt, error) {
 	fileInfos = append(fileInfos, &apiFileInfo{
 		name:    "in",
 		size:    0,
-		modTime: time.Now(),
+		modTime: fs.services.now(),
+		isDir:   true,
+	})
+
+	fileInfos = append(fileInfos, &apiFileInfo{
+		name:    "out",
+		size:    0,
+		modTime: fs.services.now(),
 		isDir:   true,
 	})
 
 	fileInfos = append(fileInfos, &apiFileInfo{
 		name:    "Hinnat",
 		size:    0,
-		modTime: time.Now(),
+		modTime: fs.services.now(),
 		isDir:   true,
 	})
 
@@ -310,11 +888,31 @@ type incomingWriterAt struct {
 	apiURL   string
 	username string
 	apiKey   string
-	filename string
+	id       string    // Upload journal ID
+	started  time.Time // When the upload was opened
+	filename string    // Name the file is delivered under
+	original string    // Name as uploaded
+	path     string
+	folder   *inbound.Folder
+	fs       *APIFileSystem
+	registry *inbound.NameRegistry // Holds the reserved name, released if the upload fails
 	data     []byte
+	err      error // Set when the upload was rejected mid-transfer
+	held     bool  // Set when the upload waits in the spool for its batch
+
+	encrypted bool // OpenPGP message to decrypt and verify before delivery
 }
 
 func (w *incomingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	// Enforce the size limits and daily byte quotas before buffering anything
+	if size := off + int64(len(p)); size > int64(len(w.data)) {
+		if err := w.fs.checkQuotaSize(w.folder, size); err != nil {
+			w.fs.services.logf("Upload rejected: %s/%s: %v", w.username, w.filename, err)
+			w.err = err
+			return 0, w.err
+		}
+	}
+
 	// Extend data slice if necessary
 	needed := int(off) + len(p)
 	if needed > len(w.data) {
@@ -327,10 +925,130 @@ func (w *incomingWriterAt) WriteAt(p []byte, off int64) (int, error) {
 	return len(p), nil
 }
 
+// TransferError is called when the connection drops before the file is closed,
+// so that the partial upload is not delivered
+func (w *incomingWriterAt) TransferError(err error) {
+	if w.err == nil {
+		w.err = fmt.Errorf("upload interrupted: %w", err)
+	}
+}
+
 func (w *incomingWriterAt) Close() error {
+	// Nothing was uploaded, nothing to deliver, and the name stays free
+	if w.err == nil && len(w.data) == 0 {
+		if w.registry != nil {
+			w.registry.Release(w.username, w.folder.Path, w.filename)
+		}
+		return nil
+	}
+
+	err := w.deliver()
+
+	// A failed upload must not block its name for the next attempt
+	if err != nil && w.registry != nil {
+		w.registry.Release(w.username, w.folder.Path, w.filename)
+	}
+
+	if err != nil {
+		w.journal(spool.StatusFailed, err.Error())
+
+		var apiErr *storage.APIError
+		if errors.As(err, &apiErr) && apiErr.Rejected() {
+			w.fs.services.keepRejected(w.username, w.folder, w.filename, w.data, apiErr)
+		}
+	} else if !w.held {
+		w.journal(spool.StatusDelivered, "")
+	}
+	return err
+}
+
+// journal records the upload's status in the spool so it shows up in /in listings
+func (w *incomingWriterAt) journal(status, reason string) {
+	if w.fs.services.Spool == nil {
+		return
+	}
+	w.fs.services.Spool.Record(w.username, w.entry(status, reason))
+}
+
+// entry returns the upload's journal entry with the given status
+func (w *incomingWriterAt) entry(status, reason string) spool.Entry {
+	return spool.Entry{
+		ID:       w.id,
+		Folder:   w.folder.Path,
+		Name:     w.filename,
+		Size:     int64(len(w.data)),
+		Time:     w.started,
+		Status:   status,
+		Reason:   reason,
+		Original: w.original,
+	}
+}
+
+func (w *incomingWriterAt) deliver() error {
+	// Never forward a partial file
+	if w.err != nil {
+		return w.err
+	}
+
 	if len(w.data) > 0 {
-		content := string(w.data)
-		return storage.SendOrderToAPI(w.apiURL, w.username, w.apiKey, w.filename, content)
+		// Keep the original bytes for audits before anything is rewritten
+		if w.fs.services.Audit != nil {
+			if _, err := w.fs.services.Audit.Save(w.username, w.filename, w.data, w.fs.services.now()); err != nil {
+				w.fs.services.logf("Failed to archive upload %s/%s: %v", w.username, w.filename, err)
+				return fmt.Errorf("failed to store upload")
+			}
+		}
+
+		plain := w.data
+		if w.encrypted {
+			decrypted, err := w.fs.decrypt(w.data)
+			if err != nil {
+				w.fs.services.logf("Upload rejected: %s/%s: %v", w.username, w.original, err)
+				return err
+			}
+			plain = decrypted
+		}
+
+		data, encoding, err := inbound.Normalize(w.fs.normalization(w.folder), plain)
+		if err != nil {
+			w.fs.services.logf("Upload rejected: %s/%s: %v", w.username, w.filename, err)
+			return err
+		}
+		if encoding != inbound.EncodingUTF8 && encoding != inbound.EncodingBinary {
+			w.fs.services.logf("Converted %s/%s from %s to UTF-8", w.username, w.filename, encoding)
+		}
+
+		// Reject invalid files now instead of letting them fail inside the ERP
+		if err := w.folder.Validate(w.filename, data); err != nil {
+			w.fs.services.logf("Upload rejected: %s/%s: %v", w.username, w.filename, err)
+			return err
+		}
+
+		var metadata *storage.UploadMetadata
+		if w.folder.EnvelopeVersion != 1 {
+			sum := sha256.Sum256(w.data)
+			metadata = &storage.UploadMetadata{
+				UploadedAt:    w.fs.services.now().UTC().Format(time.RFC3339),
+				SessionID:     w.fs.session.ID,
+				RemoteAddr:    w.fs.session.RemoteAddr,
+				ClientVersion: w.fs.session.ClientVersion,
+				SHA256:        hex.EncodeToString(sum[:]),
+				ContentType:   inbound.DetectContentType(w.filename, data),
+				Encoding:      encoding,
+				Path:          w.path,
+			}
+		}
+
+		// Batch folders deliver uploads only once their trigger file arrives
+		if w.folder.Batch != nil {
+			return w.holdForBatch(data, metadata)
+		}
+
+		w.journal(spool.StatusPending, "")
+		if err := w.fs.services.api().SendFileToAPI(w.apiURL, w.folder.Endpoint, w.folder.Envelope, w.username, w.apiKey, w.filename, data, metadata); err != nil {
+			return err
+		}
+		w.fs.recordUpload(w.folder, int64(len(w.data)))
 	}
 	return nil
 }
@@ -341,6 +1059,7 @@ type apiFileInfo struct {
 	size    int64
 	modTime time.Time
 	isDir   bool
+	mode    os.FileMode // Permission bits for files, defaults to 0644
 }
 
 func (fi *apiFileInfo) Name() string { return fi.name }
@@ -349,6 +1068,9 @@ func (fi *apiFileInfo) Mode() os.FileMode {
 	if fi.isDir {
 		return os.ModeDir | 0755
 	}
+	if fi.mode != 0 {
+		return fi.mode
+	}
 	return 0644
 }
 func (fi *apiFileInfo) ModTime() time.Time { return fi.modTime }
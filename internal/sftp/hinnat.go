@@ -0,0 +1,269 @@
+package sftp
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"sftp-service/internal/pgp"
+	"sftp-service/internal/pricelist"
+)
+
+// archiveDir is the virtual directory listing the entries of the pricelist archive
+const archiveDir = "/Hinnat/salhydro_kaikki"
+
+// historyDir is the virtual directory listing the user's dated pricelist versions
+const historyDir = "/Hinnat/arkisto"
+
+// isInArchiveDir checks if path is the archive directory or anything inside it
+func isInArchiveDir(filePath string) bool {
+	return filePath == archiveDir || strings.HasPrefix(filePath, archiveDir+"/")
+}
+
+// pricelistArchive returns the user's current pricelist archive and records it as a version
+func (fs *APIFileSystem) pricelistArchive() (*pricelist.Archive, error) {
+	archive, err := fs.services.Pricelists.Get(fs.username, fs.apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := fs.services.PricelistVersions.Save(fs.username, archive); err != nil {
+		fs.services.logf("Failed to store pricelist version for user %s: %v", fs.username, err)
+	}
+	return archive, nil
+}
+
+// readPricelistFile returns the content of a file under /Hinnat, served from the cached archive.
+// version is set for files that bring the user up to date with that pricelist version.
+func (fs *APIFileSystem) readPricelistFile(filePath string) (data []byte, version string, err error) {
+	switch {
+	case filePath == "/Hinnat/"+pricelist.ArchiveName:
+		archive, err := fs.pricelistArchive()
+		if err != nil {
+			return nil, "", err
+		}
+		return archive.Data, archive.ID, nil
+	case filePath == "/Hinnat/"+pricelist.DeltaName:
+		return fs.pricelistDelta()
+	case strings.HasPrefix(filePath, historyDir+"/"):
+		dated, ok := fs.services.PricelistVersions.FindDated(fs.username, path.Base(filePath))
+		if !ok {
+			return nil, "", os.ErrNotExist
+		}
+		archive, err := fs.services.PricelistVersions.Load(fs.username, dated.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		return archive.Data, "", nil
+	case path.Dir(filePath) == "/Hinnat" && fs.pricelistFormats().IsConverted(path.Base(filePath)):
+		archive, err := fs.pricelistArchive()
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := fs.pricelistFormats().Convert(archive, path.Base(filePath))
+		return data, "", err
+	case isInArchiveDir(filePath):
+		archive, err := fs.pricelistArchive()
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := archive.ReadEntry(strings.TrimPrefix(filePath, archiveDir))
+		return data, "", err
+	}
+
+	// Anything else is refused by the API client
+	data, err = fs.services.api().DownloadPricelist(fs.apiURL, fs.username, fs.apiKey, filePath)
+	return data, "", err
+}
+
+// pricelistDelta builds the changes between the version the user last downloaded and the current one
+func (fs *APIFileSystem) pricelistDelta() ([]byte, string, error) {
+	last, ok := fs.services.PricelistVersions.LastDownloaded(fs.username)
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+
+	archive, err := fs.pricelistArchive()
+	if err != nil {
+		return nil, "", err
+	}
+	base, err := fs.services.PricelistVersions.Load(fs.username, last.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, summary, err := archive.Delta(base)
+	if err != nil {
+		fs.services.logf("Failed to build pricelist delta for user %s: %v", fs.username, err)
+		return nil, "", err
+	}
+	fs.services.logf("Pricelist delta for user %s since %s: %d added, %d changed, %d removed",
+		fs.username, last.ID, summary.Added, summary.Changed, summary.Removed)
+	return data, archive.ID, nil
+}
+
+// pricelistFormats returns the derived pricelist files offered to the user
+func (fs *APIFileSystem) pricelistFormats() *pricelist.Formats {
+	if fs.settings.Pricelist != nil {
+		return fs.settings.Pricelist
+	}
+	return fs.services.PricelistFormats
+}
+
+// convertedFileInfo returns the listing entry of a converted pricelist file. Listings never
+// download or convert the pricelist, so the size is only known once the file has been read.
+func (fs *APIFileSystem) convertedFileInfo(name string) *apiFileInfo {
+	fileInfo := &apiFileInfo{name: name, modTime: fs.services.now()}
+	if archive, ok := fs.services.Pricelists.Peek(fs.username); ok {
+		fileInfo.size, _ = archive.ConvertedSize(name)
+		fileInfo.modTime = archive.Fetched
+	}
+	return fileInfo
+}
+
+// deltaFileInfo returns the listing entry of the delta archive, if the user has a
+// version to compare with. As with converted files the size is known once it was built.
+func (fs *APIFileSystem) deltaFileInfo() (*apiFileInfo, bool) {
+	last, ok := fs.services.PricelistVersions.LastDownloaded(fs.username)
+	if !ok {
+		return nil, false
+	}
+
+	fileInfo := &apiFileInfo{name: pricelist.DeltaName, modTime: fs.services.now()}
+	if archive, ok := fs.services.Pricelists.Peek(fs.username); ok {
+		fileInfo.size, _ = archive.DeltaSize(last.ID)
+		fileInfo.modTime = archive.Fetched
+	}
+	return fileInfo, true
+}
+
+// pricelistReader serves a pricelist file. Reading a full archive or delta to
+// the end records the version as downloaded, so the next delta starts from it.
+func (fs *APIFileSystem) pricelistReader(data []byte, version string) io.ReaderAt {
+	if version == "" {
+		return &bytesReaderAt{data: data}
+	}
+	return &downloadReaderAt{
+		bytesReaderAt: bytesReaderAt{data: data},
+		done: func() {
+			if err := fs.services.PricelistVersions.MarkDownloaded(fs.username, version); err != nil {
+				fs.services.logf("Failed to record pricelist download for user %s: %v", fs.username, err)
+				return
+			}
+			fs.services.logf("User %s now has pricelist version %s", fs.username, version)
+		},
+	}
+}
+
+// downloadReaderAt calls done on close if the whole file was read
+type downloadReaderAt struct {
+	bytesReaderAt
+	done     func()
+	complete bool
+}
+
+func (r *downloadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.bytesReaderAt.ReadAt(p, off)
+	if off+int64(n) >= int64(len(r.data)) {
+		r.complete = true
+	}
+	return n, err
+}
+
+func (r *downloadReaderAt) Close() error {
+	if r.complete {
+		r.done()
+	}
+	return nil
+}
+
+// archiveSize returns the size of the cached pricelist archive, or an estimate
+// of 2MB when it has not been downloaded recently
+func (fs *APIFileSystem) archiveSize() int64 {
+	if archive, ok := fs.services.Pricelists.Peek(fs.username); ok {
+		return int64(len(archive.Data))
+	}
+	return 2 * 1024 * 1024
+}
+
+// listArchiveDir lists or stats a path inside the pricelist archive directory
+func (fs *APIFileSystem) listArchiveDir(method, filePath string) (sftp.ListerAt, error) {
+	// Browsing needs the archive itself, but only reading a file records it as a version
+	archive, err := fs.services.Pricelists.Get(fs.username, fs.apiKey)
+	if err != nil {
+		fs.services.logf("Failed to load pricelist archive for user %s: %v", fs.username, err)
+		return nil, err
+	}
+
+	name := strings.TrimPrefix(filePath, archiveDir)
+	entry, err := archive.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "Stat" || !entry.IsDir {
+		fileInfo := archiveFileInfo(entry)
+		fileInfo.name = path.Base(filePath)
+		return &listerat{files: []os.FileInfo{fileInfo}}, nil
+	}
+
+	entries, err := archive.List(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileInfos []os.FileInfo
+	for _, entry := range entries {
+		fileInfos = append(fileInfos, archiveFileInfo(entry))
+	}
+	return &listerat{files: fileInfos}, nil
+}
+
+// listHistoryDir lists or stats the dated pricelist versions. Only versions the
+// service has fetched for this user are listed, so nobody sees another
+// customer's prices.
+func (fs *APIFileSystem) listHistoryDir(method, filePath string) (sftp.ListerAt, error) {
+	if filePath == historyDir {
+		if method == "Stat" {
+			return &listerat{files: []os.FileInfo{&apiFileInfo{name: path.Base(historyDir), modTime: fs.services.now(), isDir: true}}}, nil
+		}
+
+		var fileInfos []os.FileInfo
+		for _, dated := range fs.services.PricelistVersions.DatedVersions(fs.username) {
+			fileInfos = append(fileInfos, datedFileInfo(dated, dated.Name))
+		}
+		return &listerat{files: fileInfos}, nil
+	}
+
+	name := path.Base(filePath)
+	if fs.pgpDownloads() == pgp.DownloadsSuffix {
+		name = strings.TrimSuffix(name, pgp.EncryptedSuffix)
+	}
+	dated, ok := fs.services.PricelistVersions.FindDated(fs.username, name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &listerat{files: []os.FileInfo{datedFileInfo(dated, path.Base(filePath))}}, nil
+}
+
+// datedFileInfo converts a stored pricelist version for listings
+func datedFileInfo(dated pricelist.Dated, name string) *apiFileInfo {
+	return &apiFileInfo{
+		name:    name,
+		size:    dated.Size,
+		modTime: dated.Fetched,
+	}
+}
+
+// archiveFileInfo converts an archive entry for listings
+func archiveFileInfo(entry pricelist.Entry) *apiFileInfo {
+	return &apiFileInfo{
+		name:    entry.Name,
+		size:    entry.Size,
+		modTime: entry.Modified,
+		isDir:   entry.IsDir,
+	}
+}
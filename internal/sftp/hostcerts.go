@@ -0,0 +1,114 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostCertificateSuffix names the certificate of a key file, as written by ssh-keygen -s
+const hostCertificateSuffix = "-cert.pub"
+
+// certificateExpiryWarning is how long before expiry a host certificate is logged as expiring soon
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// addCertificateFile loads the certificate of a key file when it exists next to it
+func (k *hostKeySet) addCertificateFile(keyPath string, now time.Time, logf func(format string, args ...interface{})) error {
+	certPath := keyPath + hostCertificateSuffix
+	if _, err := os.Stat(certPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return k.addCertificate(certPath, now, logf)
+}
+
+// addCertificate loads an OpenSSH host certificate for one of the loaded keys,
+// checking that it is a valid host certificate signed by its CA
+func (k *hostKeySet) addCertificate(certPath string, now time.Time, logf func(format string, args ...interface{})) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("failed to read host certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse host certificate %s: %w", certPath, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("%s is a public key, not a certificate", certPath)
+	}
+	if cert.CertType != ssh.HostCert {
+		return fmt.Errorf("%s is a user certificate, not a host certificate", certPath)
+	}
+
+	signer := k.find(cert.Key.Marshal())
+	if signer == nil {
+		return fmt.Errorf("host certificate %s does not match any host key (certified key %s)",
+			certPath, ssh.FingerprintSHA256(cert.Key))
+	}
+	if err := checkHostCertificate(cert, now); err != nil {
+		return fmt.Errorf("host certificate %s: %w", certPath, err)
+	}
+
+	for _, other := range k.certificates {
+		if other.PublicKey().Type() == cert.Type() {
+			logf("Ignoring host certificate %s, another %s certificate is in use", certPath, cert.Type())
+			return nil
+		}
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return fmt.Errorf("host certificate %s: %w", certPath, err)
+	}
+	k.certificates = append(k.certificates, certSigner)
+
+	principals := "any host"
+	if len(cert.ValidPrincipals) > 0 {
+		principals = strings.Join(cert.ValidPrincipals, ", ")
+	}
+	logf("Loaded host certificate %s for %s, serial %d, principals %s, valid until %s, signed by CA %s",
+		certPath, ssh.FingerprintSHA256(cert.Key), cert.Serial, principals,
+		certificateExpiry(cert), ssh.FingerprintSHA256(cert.SignatureKey))
+
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		if left := time.Unix(int64(cert.ValidBefore), 0).Sub(now); left < certificateExpiryWarning {
+			logf("Host certificate %s expires in %d days, renew it before then", certPath, int(left.Hours()/24))
+		}
+	}
+	return nil
+}
+
+// checkHostCertificate verifies the certificate's validity period and CA signature
+func checkHostCertificate(cert *ssh.Certificate, now time.Time) error {
+	if after := time.Unix(int64(cert.ValidAfter), 0); now.Before(after) {
+		return fmt.Errorf("not valid before %s", after.Format(time.RFC3339))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		if before := time.Unix(int64(cert.ValidBefore), 0); !now.Before(before) {
+			return fmt.Errorf("expired at %s", before.Format(time.RFC3339))
+		}
+	}
+
+	// CheckCert wants one of the principals, the host names are up to the clients
+	var principal string
+	if len(cert.ValidPrincipals) > 0 {
+		principal = cert.ValidPrincipals[0]
+	}
+	checker := ssh.CertChecker{Clock: func() time.Time { return now }}
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return err
+	}
+	return nil
+}
+
+// certificateExpiry formats the end of a certificate's validity
+func certificateExpiry(cert *ssh.Certificate) string {
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		return "forever"
+	}
+	return time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339)
+}
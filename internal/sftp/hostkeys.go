@@ -0,0 +1,310 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeys configures where the server's host keys come from
+type HostKeys struct {
+	Paths        []string // Private key files in PEM or OpenSSH format, with an optional <path>-cert.pub certificate
+	PEM          []byte   // Private keys as PEM blocks or base64-encoded PEM, e.g. from a secret
+	Generate     bool     // Create missing key files, the key type follows the file name
+	Certificates []string // Further OpenSSH host certificate files for any of the keys
+}
+
+// OpenSSH host key update extension, see PROTOCOL in the OpenSSH sources
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// hostKeySet holds the loaded host keys. Only the first key of each type is
+// used in handshakes, the others are announced to clients ahead of a rotation.
+type hostKeySet struct {
+	active       []ssh.Signer
+	all          []ssh.Signer
+	certificates []ssh.Signer // Certificate signers, offered in handshakes next to the plain keys
+}
+
+// signers returns the keys and certificates offered in handshakes
+func (k *hostKeySet) signers() []ssh.Signer {
+	return append(append([]ssh.Signer{}, k.active...), k.certificates...)
+}
+
+// loadHostKeys loads the configured host keys, generating missing files when allowed
+func loadHostKeys(cfg HostKeys, now time.Time, logf func(format string, args ...interface{})) (*hostKeySet, error) {
+	keys := &hostKeySet{}
+
+	if len(bytes.TrimSpace(cfg.PEM)) > 0 {
+		signers, err := parseHostKeys(cfg.PEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host keys: %w", err)
+		}
+		for _, signer := range signers {
+			keys.add(signer, "environment", logf)
+		}
+	}
+
+	for _, path := range cfg.Paths {
+		signer, err := loadOrCreateHostKey(path, cfg.Generate, logf)
+		if err != nil {
+			return nil, err
+		}
+		keys.add(signer, path, logf)
+		if err := keys.addCertificateFile(path, now, logf); err != nil {
+			return nil, err
+		}
+	}
+	for _, certPath := range cfg.Certificates {
+		if err := keys.addCertificate(certPath, now, logf); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys.active) == 0 {
+		return nil, fmt.Errorf("no host keys configured")
+	}
+	return keys, nil
+}
+
+// add registers a key unless the same key was already loaded
+func (k *hostKeySet) add(signer ssh.Signer, source string, logf func(format string, args ...interface{})) {
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+	for _, other := range k.all {
+		if bytes.Equal(other.PublicKey().Marshal(), signer.PublicKey().Marshal()) {
+			logf("Ignoring duplicate host key %s from %s", fingerprint, source)
+			return
+		}
+	}
+	k.all = append(k.all, signer)
+
+	for _, other := range k.active {
+		if other.PublicKey().Type() == signer.PublicKey().Type() {
+			logf("Loaded host key %s %s from %s (announced only, another %s key is in use)",
+				signer.PublicKey().Type(), fingerprint, source, signer.PublicKey().Type())
+			return
+		}
+	}
+	k.active = append(k.active, signer)
+	logf("Loaded host key %s %s from %s", signer.PublicKey().Type(), fingerprint, source)
+}
+
+// parseHostKeys parses concatenated PEM blocks, or base64-encoded PEM
+// separated by commas or whitespace when the data holds no PEM header
+func parseHostKeys(data []byte) ([]ssh.Signer, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		var signers []ssh.Signer
+		fields := strings.FieldsFunc(string(data), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+		})
+		for _, field := range fields {
+			decoded, err := base64.StdEncoding.DecodeString(field)
+			if err != nil {
+				return nil, fmt.Errorf("host key is neither PEM nor base64: %w", err)
+			}
+			if !bytes.Contains(decoded, []byte("-----BEGIN")) {
+				return nil, fmt.Errorf("base64 host key does not contain PEM")
+			}
+			parsed, err := parseHostKeys(decoded)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, parsed...)
+		}
+		return signers, nil
+	}
+
+	var signers []ssh.Signer
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		signer, err := ssh.ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no PEM blocks found")
+	}
+	return signers, nil
+}
+
+// loadOrCreateHostKey loads a host key file, or creates it when it is missing and generate is set
+func loadOrCreateHostKey(hostKeyPath string, generate bool, logf func(format string, args ...interface{})) (ssh.Signer, error) {
+	keyData, err := os.ReadFile(hostKeyPath)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", hostKeyPath, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read host key: %w", err)
+	}
+	if !generate {
+		return nil, fmt.Errorf("host key %s does not exist and generating host keys is disabled", hostKeyPath)
+	}
+
+	logf("Creating new host key at %s", hostKeyPath)
+	key, err := generateHostKey(filepath.Base(hostKeyPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	// Write a temporary file first so that a failed write never leaves a
+	// truncated key behind, then link it into place. Linking fails when another
+	// instance sharing the directory created the key in the meantime.
+	keyFile, err := os.CreateTemp(filepath.Dir(hostKeyPath), "."+filepath.Base(hostKeyPath)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host key file: %w", err)
+	}
+	defer os.Remove(keyFile.Name())
+
+	if err := pem.Encode(keyFile, block); err != nil {
+		keyFile.Close()
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	if err := keyFile.Sync(); err != nil {
+		keyFile.Close()
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+	if err := keyFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write host key: %w", err)
+	}
+
+	if err := os.Link(keyFile.Name(), hostKeyPath); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return loadOrCreateHostKey(hostKeyPath, false, logf)
+		}
+		return nil, fmt.Errorf("failed to create host key file: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer from key: %w", err)
+	}
+	return signer, nil
+}
+
+// generateHostKey creates an Ed25519 or ECDSA P-256 key when the file name
+// says so, and an RSA-3072 key otherwise
+func generateHostKey(name string) (crypto.Signer, error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.Contains(name, "ed25519"):
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case strings.Contains(name, "ecdsa"):
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	}
+}
+
+// announce sends all host keys to the client, which adds the ones it does not
+// know yet to known_hosts after asking the server to prove them
+func (k *hostKeySet) announce(conn ssh.Conn) error {
+	var payload []byte
+	for _, signer := range k.all {
+		blob := signer.PublicKey().Marshal()
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(blob)))
+		payload = append(payload, blob...)
+	}
+	_, _, err := conn.SendRequest(hostKeysRequest, false, payload)
+	return err
+}
+
+// prove signs the session ID with each host key requested by the client
+func (k *hostKeySet) prove(conn ssh.Conn, payload []byte) ([]byte, error) {
+	var negotiated string
+	if meta, ok := conn.(ssh.AlgorithmsConnMetadata); ok {
+		negotiated = meta.Algorithms().HostKey
+	}
+
+	var proof []byte
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("malformed %s request", hostKeysProveRequest)
+		}
+		size := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < size {
+			return nil, fmt.Errorf("malformed %s request", hostKeysProveRequest)
+		}
+		blob := payload[4 : 4+size]
+		payload = payload[4+size:]
+
+		signer := k.find(blob)
+		if signer == nil {
+			return nil, fmt.Errorf("client asked to prove an unknown host key")
+		}
+
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, conn.SessionID(), blob})
+
+		sig, err := signHostKeyProof(signer, data, negotiated)
+		if err != nil {
+			return nil, err
+		}
+		sigBlob := ssh.Marshal(sig)
+		proof = binary.BigEndian.AppendUint32(proof, uint32(len(sigBlob)))
+		proof = append(proof, sigBlob...)
+	}
+	return proof, nil
+}
+
+// find returns the host key with the given public key blob
+func (k *hostKeySet) find(blob []byte) ssh.Signer {
+	for _, signer := range k.all {
+		if bytes.Equal(signer.PublicKey().Marshal(), blob) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// signHostKeyProof signs with the key's own algorithm. OpenSSH expects RSA
+// proofs in the RSA algorithm negotiated for the session, also when it was
+// negotiated for a certificate, and rsa-sha2-512 otherwise.
+func signHostKeyProof(signer ssh.Signer, data []byte, negotiated string) (*ssh.Signature, error) {
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok || signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer.Sign(rand.Reader, data)
+	}
+
+	algorithm := ssh.KeyAlgoRSASHA512
+	switch underlying := strings.TrimSuffix(negotiated, "-cert-v01@openssh.com"); underlying {
+	case ssh.KeyAlgoRSA, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512:
+		algorithm = underlying
+	}
+	return algorithmSigner.SignWithAlgorithm(rand.Reader, data, algorithm)
+}
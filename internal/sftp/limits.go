@@ -0,0 +1,244 @@
+package sftp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Limits bound the server's connections. Zero means unlimited.
+type Limits struct {
+	MaxConnections           int `json:"max_connections"`             // Open client connections in total
+	MaxConnectionsPerIP      int `json:"max_connections_per_ip"`      // Open connections from one source address
+	MaxSessionsPerUser       int `json:"max_sessions_per_user"`       // Authenticated connections of one user
+	MaxChannelsPerConnection int `json:"max_channels_per_connection"` // Session channels on one connection
+}
+
+// SSH disconnect reason codes from RFC 4253 section 11.1
+const (
+	disconnectTooManyConnections = 12
+	disconnectByApplication      = 11
+)
+
+// serverVersion is the identification string sent to rejected clients, the same as x/crypto/ssh's default
+const serverVersion = "SSH-2.0-Go"
+
+// Stats are the server's current connection counts
+type Stats struct {
+	Connections  int            `json:"connections"`
+	Sessions     int            `json:"sessions"` // Authenticated connections
+	Channels     int            `json:"channels"`
+	PerIP        map[string]int `json:"per_ip"`
+	PerUser      map[string]int `json:"per_user"`
+	Rejected     RejectCounts   `json:"rejected"` // Since the server started
+	ShuttingDown bool           `json:"shutting_down"`
+	Limits       Limits         `json:"limits"`
+}
+
+// RejectCounts count refused connections, sessions and channels by limit
+type RejectCounts struct {
+	Connections   int64 `json:"connections"`
+	ConnectionsIP int64 `json:"connections_per_ip"`
+	Sessions      int64 `json:"sessions_per_user"`
+	Channels      int64 `json:"channels_per_connection"`
+}
+
+// errTooManySessions is returned by the password callback when the user is at their session limit
+var errTooManySessions = errors.New("too many sessions")
+
+// remoteIP returns the source address of a connection without its port
+func remoteIP(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// admit adds a connection unless the server is shutting down or a connection
+// limit is reached, in which case it returns the disconnect reason for the client.
+// Connections whose client address is not known yet skip the per-address limit
+// until admitAddress.
+func (s *Server) admit(conn *connTracker) (uint32, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown {
+		return disconnectByApplication, "server is shutting down", false
+	}
+	if max := s.limits.MaxConnections; max > 0 && len(s.conns) >= max {
+		s.rejected.Connections++
+		return disconnectTooManyConnections, fmt.Sprintf("too many connections (limit %d)", max), false
+	}
+	if conn.ip != "" {
+		if reason, message, ok := s.checkAddress(conn.ip); !ok {
+			return reason, message, false
+		}
+	}
+
+	s.conns[conn] = struct{}{}
+	return 0, "", true
+}
+
+// admitAddress sets the client address of an admitted connection once its
+// PROXY header was read, and checks the per-address limit for it
+func (s *Server) admitAddress(conn *connTracker, addr net.Addr) (uint32, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ip := remoteIP(addr)
+	if reason, message, ok := s.checkAddress(ip); !ok {
+		return reason, message, false
+	}
+	conn.ip = ip
+	conn.setAddr(addr)
+	return 0, "", true
+}
+
+// checkAddress checks the per-address limit, s.mu must be held
+func (s *Server) checkAddress(ip string) (uint32, string, bool) {
+	max := s.limits.MaxConnectionsPerIP
+	if max <= 0 {
+		return 0, "", true
+	}
+
+	var fromIP int
+	for other := range s.conns {
+		if other.ip == ip {
+			fromIP++
+		}
+	}
+	if fromIP >= max {
+		s.rejected.ConnectionsIP++
+		return disconnectTooManyConnections, fmt.Sprintf("too many connections from %s (limit %d)", ip, max), false
+	}
+	return 0, "", true
+}
+
+// userSessions counts the authenticated connections of a user
+func (s *Server) userSessions(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions int
+	for conn := range s.conns {
+		if conn.user() == username {
+			sessions++
+		}
+	}
+	return sessions
+}
+
+// checkUserSessions refuses a login when the user already has the maximum number of sessions
+func (s *Server) checkUserSessions(username string) error {
+	max := s.limits.MaxSessionsPerUser
+	if max <= 0 || s.userSessions(username) < max {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.rejected.Sessions++
+	s.mu.Unlock()
+
+	s.services.logf("Session limit reached for user %s (limit %d)", username, max)
+	return &ssh.BannerError{
+		Err:     errTooManySessions,
+		Message: fmt.Sprintf("Too many concurrent sessions for %s (limit %d), please close one and try again.\r\n", username, max),
+	}
+}
+
+// allowChannel reports whether a connection may open another session channel
+func (s *Server) allowChannel(conn *connTracker) bool {
+	max := s.limits.MaxChannelsPerConnection
+	if max <= 0 || conn.channelCount() < max {
+		return true
+	}
+
+	s.mu.Lock()
+	s.rejected.Channels++
+	s.mu.Unlock()
+	return false
+}
+
+// rejectConnection answers a client that was not admitted with an SSH
+// disconnect message. Before the key exchange packets are not encrypted, so
+// the message can be sent right after the identification string.
+func rejectConnection(conn net.Conn, reason uint32, message string) {
+	payload := []byte{1} // SSH_MSG_DISCONNECT
+	payload = binary.BigEndian.AppendUint32(payload, reason)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(message)))
+	payload = append(payload, message...)
+	payload = binary.BigEndian.AppendUint32(payload, 0) // Language tag
+
+	// Packets are padded to a multiple of 8 bytes with at least 4 bytes of padding
+	padding := 8 - (5+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+
+	// Read the client's identification first and drain what it sends before
+	// closing, otherwise the client may see a reset instead of the message
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	conn.Write([]byte(serverVersion + "\r\n"))
+	bufio.NewReader(conn).ReadString('\n')
+	conn.Write(packet)
+	if tcpConn, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcpConn.CloseWrite()
+	}
+	io.Copy(io.Discard, conn)
+}
+
+// Stats returns the current connection counts
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Connections:  len(s.conns),
+		PerIP:        make(map[string]int),
+		PerUser:      make(map[string]int),
+		Rejected:     s.rejected,
+		ShuttingDown: s.shuttingDown,
+		Limits:       s.limits,
+	}
+	for conn := range s.conns {
+		if conn.ip != "" {
+			stats.PerIP[conn.ip]++
+		}
+		stats.Channels += conn.channelCount()
+		if username := conn.user(); username != "" {
+			stats.PerUser[username]++
+			stats.Sessions++
+		}
+	}
+	return stats
+}
+
+// StatusHandler serves Stats as JSON for monitoring. The response lists the
+// source addresses and users with open connections, so it should not be
+// reachable from the internet.
+func (s *Server) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := s.Stats()
+
+		w.Header().Set("Content-Type", "application/json")
+		if stats.ShuttingDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(stats)
+	})
+}
@@ -0,0 +1,101 @@
+package sftp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"sftp-service/internal/proxyproto"
+)
+
+// addrConn is a connection that only reports its peer address
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// acceptListener hands out one prepared connection
+type acceptListener struct {
+	net.Listener
+	conn net.Conn
+}
+
+func (l acceptListener) Accept() (net.Conn, error) { return l.conn, nil }
+
+func tcpAddr(t *testing.T, addr string) *net.TCPAddr {
+	t.Helper()
+	parsed, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// proxiedConn returns a connection from the proxy whose header has not been read
+func proxiedConn(t *testing.T, proxy string) *proxyproto.Conn {
+	t.Helper()
+	trusted, _ := proxyproto.ParseCIDRs([]string{"10.0.0.0/8"})
+	listener := proxyproto.NewListener(acceptListener{conn: addrConn{remote: tcpAddr(t, proxy)}}, proxyproto.Config{Trusted: trusted})
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.(*proxyproto.Conn)
+}
+
+func TestAdmitLimits(t *testing.T) {
+	s := &Server{
+		conns:  make(map[*connTracker]struct{}),
+		limits: Limits{MaxConnections: 4, MaxConnectionsPerIP: 2},
+	}
+
+	direct := func(addr string) *connTracker {
+		return newConnTracker(addrConn{remote: tcpAddr(t, addr)})
+	}
+
+	// Direct connections are limited per source address right away
+	for i, want := range []bool{true, true, false} {
+		if _, message, ok := s.admit(direct(fmt.Sprintf("203.0.113.7:%d", 40000+i))); ok != want {
+			t.Fatalf("direct connection %d admitted = %v (%s)", i, ok, message)
+		}
+	}
+
+	// Connections waiting for their PROXY header count against the total only,
+	// even though they all come from the load balancer's address
+	waiting := newConnTracker(proxiedConn(t, "10.0.1.5:30000"))
+	if _, message, ok := s.admit(waiting); !ok {
+		t.Fatalf("proxied connection refused: %s", message)
+	}
+	if waiting.String() != "10.0.1.5:30000" {
+		t.Errorf("tracker address = %s, want the proxy's", waiting)
+	}
+	second := newConnTracker(proxiedConn(t, "10.0.1.5:30001"))
+	if _, message, ok := s.admit(second); !ok {
+		t.Fatalf("proxied connection refused: %s", message)
+	}
+	if _, _, ok := s.admit(newConnTracker(proxiedConn(t, "10.0.1.5:30002"))); ok {
+		t.Fatal("connection over the total limit admitted while others wait for headers")
+	}
+	if stats := s.Stats(); stats.Connections != 4 || len(stats.PerIP) != 1 {
+		t.Errorf("Stats = %d connections, per IP %v", stats.Connections, stats.PerIP)
+	}
+
+	// The client address from the header is checked against the per-address limit
+	if _, message, ok := s.admitAddress(waiting, tcpAddr(t, "198.51.100.9:5000")); !ok {
+		t.Fatalf("admitAddress refused: %s", message)
+	}
+	if waiting.String() != "198.51.100.9:5000" {
+		t.Errorf("tracker address = %s after the header", waiting)
+	}
+	if _, message, ok := s.admitAddress(second, tcpAddr(t, "203.0.113.7:5001")); ok {
+		t.Fatal("admitAddress admitted a third connection from 203.0.113.7")
+	} else if message != "too many connections from 203.0.113.7 (limit 2)" {
+		t.Errorf("message = %q", message)
+	}
+
+	if got := s.Stats().Rejected; got.Connections != 1 || got.ConnectionsIP != 2 {
+		t.Errorf("rejected = %+v", got)
+	}
+}
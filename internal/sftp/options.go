@@ -0,0 +1,113 @@
+package sftp
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"sftp-service/internal/auth"
+	"sftp-service/internal/config"
+)
+
+// Authenticator checks a user's password and returns the user the session runs as
+type Authenticator interface {
+	AuthenticateUser(username, password string) (*auth.User, error)
+}
+
+// RemoteAuthenticator is an Authenticator that is also told the client's address
+type RemoteAuthenticator interface {
+	Authenticator
+	AuthenticateUserFrom(username, password, remoteAddr string) (*auth.User, error)
+}
+
+// FileSystem serves the requests of one SFTP session
+type FileSystem interface {
+	sftp.FileReader
+	sftp.FileWriter
+	sftp.FileCmder
+	sftp.FileLister
+}
+
+// SessionUser is the authenticated user a session's file system is created for
+type SessionUser struct {
+	Username string
+	UserID   string
+	APIKey   string
+	Session  Session
+	Settings config.UserSettings // Per-user overrides, quota merged with the login response
+}
+
+// FileSystemFactory creates the file system of a new session
+type FileSystemFactory func(user SessionUser) FileSystem
+
+// TransferInfo describes a finished upload or download
+type TransferInfo struct {
+	Username string
+	Session  Session
+	Path     string
+	Upload   bool
+	Err      error // Set when the transfer or the order delivery failed
+}
+
+// Hooks are called at points of the server's life cycle. Every hook is optional
+// and runs synchronously, so it should return quickly.
+type Hooks struct {
+	OnListen     func(addr net.Addr)                           // Serve starts accepting connections
+	OnConnect    func(addr net.Addr)                           // A client connected, before the SSH handshake
+	OnLogin      func(user SessionUser)                        // A user authenticated and the session starts
+	OnDisconnect func(user SessionUser)                        // An authenticated connection closed
+	OnTransfer   func(info TransferInfo)                       // A file was closed after reading or writing
+	OnShutdown   func(aborted []string, elapsed time.Duration) // Shutdown finished, listing aborted transfers
+}
+
+// options are the settings applied by Option values
+type options struct {
+	logger        *log.Logger
+	clock         func() time.Time
+	fileSystems   FileSystemFactory
+	authenticator Authenticator
+	hooks         Hooks
+}
+
+// Option configures a Server created by NewServer
+type Option func(*options)
+
+// WithLogger sends the log output of the server, its file systems and the spool,
+// pricelist and API client they use to logger instead of the standard logger
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithClock replaces time.Now for upload times, listings, quotas, batch deadlines,
+// spool and pricelist retention and API timestamps. Network deadlines and idle
+// timeouts keep the wall clock.
+func WithClock(clock func() time.Time) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithFileSystemFactory replaces the API-backed file system of each session
+func WithFileSystemFactory(factory FileSystemFactory) Option {
+	return func(o *options) {
+		o.fileSystems = factory
+	}
+}
+
+// WithAuthenticator replaces Config.Authenticator
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(o *options) {
+		o.authenticator = authenticator
+	}
+}
+
+// WithHooks sets the life cycle hooks
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
@@ -0,0 +1,79 @@
+package sftp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sftp-service/internal/pgp"
+)
+
+// decryptsUploads reports whether the user's .pgp and .asc uploads are decrypted before delivery
+func (fs *APIFileSystem) decryptsUploads() bool {
+	return fs.services.PGPKey != nil && fs.settings.PGP != nil
+}
+
+// decrypt decrypts an upload with the service key and verifies the customer's signature
+func (fs *APIFileSystem) decrypt(data []byte) ([]byte, error) {
+	plain, err := pgp.Decrypt(data, fs.services.PGPKey, fs.settings.PGP.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	fs.services.logf("Decrypted upload from user %s (%d bytes)", fs.username, len(plain))
+	return plain, nil
+}
+
+// pgpDownloads returns the user's download encryption mode
+func (fs *APIFileSystem) pgpDownloads() string {
+	if fs.settings.PGP == nil {
+		return pgp.DownloadsOff
+	}
+	return fs.settings.PGP.Downloads
+}
+
+// encryptedDownload returns the plain pricelist path behind a path that is served encrypted
+func (fs *APIFileSystem) encryptedDownload(filePath string) (string, bool) {
+	if !strings.HasPrefix(filePath, "/Hinnat/") {
+		return "", false
+	}
+
+	switch fs.pgpDownloads() {
+	case pgp.DownloadsSuffix:
+		if strings.HasSuffix(filePath, pgp.EncryptedSuffix) {
+			return strings.TrimSuffix(filePath, pgp.EncryptedSuffix), true
+		}
+	case pgp.DownloadsTransparent:
+		return filePath, true
+	}
+	return "", false
+}
+
+// hideEncryptedSizes lists the files in dir that are served encrypted without a
+// size, since their encrypted size is only known once they have been downloaded
+func (fs *APIFileSystem) hideEncryptedSizes(dir string, fileInfos []os.FileInfo) {
+	for _, fileInfo := range fileInfos {
+		info, ok := fileInfo.(*apiFileInfo)
+		if !ok || info.isDir {
+			continue
+		}
+		if _, encrypted := fs.encryptedDownload(filepath.Join(dir, info.name)); encrypted {
+			info.size = 0
+		}
+	}
+}
+
+// readEncryptedPricelist downloads a pricelist and encrypts it to the customer's key
+func (fs *APIFileSystem) readEncryptedPricelist(plainPath string) ([]byte, string, error) {
+	data, version, err := fs.readPricelistFile(plainPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encrypted, err := pgp.Encrypt(data, filepath.Base(plainPath), fs.settings.PGP.PublicKey(), fs.services.PGPKey)
+	if err != nil {
+		fs.services.logf("Failed to encrypt %s for user %s: %v", plainPath, fs.username, err)
+		return nil, "", err
+	}
+	fs.services.logf("Encrypted %s for user %s (%d bytes)", plainPath, fs.username, len(encrypted))
+	return encrypted, version, nil
+}
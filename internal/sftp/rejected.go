@@ -0,0 +1,87 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/spool"
+	"sftp-service/internal/storage"
+
+	"github.com/pkg/sftp"
+)
+
+// rejectedDir is the per-user dead-letter folder holding uploads the API refused
+const rejectedDir = "/in/.rejected"
+
+// isRejectedPath checks if path is a file inside the dead-letter folder
+func isRejectedPath(filePath string) bool {
+	return path.Dir(filePath) == rejectedDir
+}
+
+// keepRejected keeps an upload the API refused with a 4xx in the user's dead-letter folder
+func (s *Services) keepRejected(username string, folder *inbound.Folder, filename string, data []byte, apiErr *storage.APIError) {
+	if s.Spool == nil {
+		return
+	}
+
+	now := s.now()
+	var reason strings.Builder
+	fmt.Fprintf(&reason, "file: %s\n", filename)
+	fmt.Fprintf(&reason, "folder: %s\n", folder.Path)
+	fmt.Fprintf(&reason, "rejected: %s\n", now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&reason, "status: HTTP %d\n\n", apiErr.StatusCode)
+	reason.WriteString(apiErr.Body)
+	if !strings.HasSuffix(apiErr.Body, "\n") {
+		reason.WriteString("\n")
+	}
+
+	stored, err := s.Spool.Reject(username, filename, data, []byte(reason.String()), now)
+	if err != nil {
+		s.logf("Failed to keep rejected upload %s/%s: %v", username, filename, err)
+		return
+	}
+	s.logf("Rejected upload %s/%s kept as %s/%s", username, filename, rejectedDir, stored)
+}
+
+// listRejectedDirectory returns the files in the user's dead-letter folder
+func (fs *APIFileSystem) listRejectedDirectory() (sftp.ListerAt, error) {
+	if fs.services.Spool == nil {
+		return nil, os.ErrNotExist
+	}
+	files, err := fs.services.Spool.Files(spool.AreaRejected, fs.username)
+	if err != nil {
+		fs.services.logf("Failed to list dead-letter folder for %s: %v", fs.username, err)
+		return nil, err
+	}
+
+	var fileInfos []os.FileInfo
+	for _, file := range files {
+		fileInfos = append(fileInfos, spoolFileInfo(file))
+	}
+	return &listerat{files: fileInfos}, nil
+}
+
+// statRejectedFile returns file info for a single file in the dead-letter folder
+func (fs *APIFileSystem) statRejectedFile(name string) (sftp.ListerAt, error) {
+	if fs.services.Spool == nil {
+		return nil, os.ErrNotExist
+	}
+	file, err := fs.services.Spool.StatFile(spool.AreaRejected, fs.username, name)
+	if err != nil {
+		return nil, err
+	}
+	return &listerat{files: []os.FileInfo{spoolFileInfo(file)}}, nil
+}
+
+// spoolFileInfo converts a file kept in the spool for listings
+func spoolFileInfo(file spool.File) os.FileInfo {
+	return &apiFileInfo{
+		name:    file.Name,
+		size:    file.Size,
+		modTime: file.ModTime,
+	}
+}
@@ -1,74 +1,278 @@
 package sftp
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 
 	"sftp-service/internal/auth"
+	"sftp-service/internal/config"
+	"sftp-service/internal/inbound"
+	"sftp-service/internal/pgp"
+	"sftp-service/internal/pricelist"
+	"sftp-service/internal/proxyproto"
+	"sftp-service/internal/quota"
+	"sftp-service/internal/spool"
 )
 
 type Server struct {
-	authenticator *auth.WebAPIAuthenticator
+	authenticator Authenticator
 	baseURL       string
-	hostKey       ssh.Signer
+	hostKeys      *hostKeySet
+	hostSigners   []ssh.Signer // Host keys and certificates limited to the crypto policy
+	algorithms    ssh.Algorithms
+	auditProfile  string
+	proxy         proxyproto.Config
 	port          string
+	services      *Services
+	users         map[string]config.UserSettings
+	fileSystems   FileSystemFactory
+	hooks         Hooks
+	limits        Limits
+	timeouts      Timeouts
+
+	mu           sync.Mutex
+	listener     net.Listener
+	conns        map[*connTracker]struct{} // Open client connections, see Shutdown
+	shuttingDown bool
+	rejected     RejectCounts
 }
 
 type Config struct {
-	Authenticator *auth.WebAPIAuthenticator
-	BaseURL       string
-	HostKeyPath   string
-	Port          string
+	Authenticator    Authenticator
+	BaseURL          string
+	HostKeys         HostKeys
+	Port             string
+	InboundFolders   []inbound.Folder
+	SpoolDir         string                         // Upload journal directory, in memory when empty
+	SpoolRetention   time.Duration                  // How long journal entries are kept
+	ListingWindow    time.Duration                  // How far back uploads are listed in /in
+	ListingStatus    string                         // ListingStatusSuffix or ListingStatusFiles
+	AuditDir         string                         // Keeps original uploads when set
+	PGPKeyFile       string                         // Service OpenPGP private key, PGP disabled when empty
+	PGPPassphrase    string                         // Unlocks the private key if it is protected
+	PricelistTTL     time.Duration                  // How long a downloaded pricelist archive is reused
+	PricelistDir     string                         // Keeps pricelist versions for deltas and /Hinnat/arkisto, in memory when empty
+	PricelistKeep    time.Duration                  // How long pricelist versions are kept, 0 keeps all
+	PricelistFormats *pricelist.Formats             // Derived pricelist files for users without their own formats
+	Users            map[string]config.UserSettings // Per-user overrides
+	Limits           Limits                         // Connection, session and channel limits
+	Timeouts         Timeouts                       // Handshake, idle and session timeouts and keepalives
+	Crypto           CryptoPolicy                   // Algorithms offered to clients, library defaults when empty
+	ProxyProtocol    proxyproto.Config              // Load balancers that send PROXY protocol headers, off when none are trusted
 }
 
 // NewServer creates a new SFTP server
-func NewServer(config *Config) (*Server, error) {
-	hostKey, err := loadOrCreateHostKey(config.HostKeyPath)
+func NewServer(config *Config, opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	authenticator := o.authenticator
+	if authenticator == nil {
+		authenticator = config.Authenticator
+	}
+	if authenticator == nil {
+		return nil, fmt.Errorf("no authenticator configured")
+	}
+
+	services := &Services{
+		Logger: o.logger,
+		Clock:  o.clock,
+	}
+
+	hostKeys, err := loadHostKeys(config.HostKeys, services.now(), services.logf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load host keys: %w", err)
+	}
+
+	algorithms, err := cryptoAlgorithms(config.Crypto)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crypto policy: %w", err)
+	}
+	hostSigners, err := restrictHostKeys(hostKeys.signers(), algorithms.HostKeys, services.logf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crypto policy: %w", err)
+	}
+	if config.Crypto.Profile != "" || len(algorithms.KeyExchanges)+len(algorithms.Ciphers)+len(algorithms.MACs)+len(algorithms.HostKeys) > 0 {
+		services.logf("Crypto policy %q: kex %v, ciphers %v, macs %v, public keys %v", config.Crypto.Profile,
+			algorithms.KeyExchanges, algorithms.Ciphers, algorithms.MACs, algorithms.HostKeys)
+	}
+
+	folders := config.InboundFolders
+	if len(folders) == 0 {
+		folders = inbound.DefaultFolders()
+	}
+
+	uploads, err := spool.New(config.SpoolDir, config.SpoolRetention)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load host key: %w", err)
+		return nil, err
+	}
+	uploads.SetLogger(services.Logger)
+	uploads.SetClock(services.now)
+
+	listingStatus := config.ListingStatus
+	if listingStatus == "" {
+		listingStatus = ListingStatusSuffix
+	}
+
+	var audit *inbound.Archive
+	if config.AuditDir != "" {
+		audit = inbound.NewArchive(config.AuditDir)
+		audit.SetLogger(services.Logger)
+	}
+
+	var pgpKey openpgp.EntityList
+	if config.PGPKeyFile != "" {
+		pgpKey, err = pgp.LoadPrivateKey(config.PGPKeyFile, config.PGPPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		services.logf("Loaded PGP key from %s", config.PGPKeyFile)
 	}
 
-	return &Server{
-		authenticator: config.Authenticator,
+	versions, err := pricelist.NewStore(config.PricelistDir, config.PricelistKeep)
+	if err != nil {
+		return nil, err
+	}
+	versions.SetLogger(services.Logger)
+	versions.SetClock(services.now)
+
+	quotas := quota.NewTracker()
+	quotas.SetClock(services.now)
+
+	services.Folders = inbound.NewRouter(folders)
+	services.Audit = audit
+	services.Quotas = quotas
+	services.Names = inbound.NewNameRegistry()
+	services.Spool = uploads
+	services.PGPKey = pgpKey
+	services.Pricelists = pricelist.NewCache(config.PricelistTTL, func(username, apiKey string) ([]byte, error) {
+		return services.api().DownloadPricelist(config.BaseURL, username, apiKey, "/Hinnat/"+pricelist.ArchiveName)
+	}, services.now)
+	services.Pricelists.SetLogger(services.Logger)
+	services.PricelistVersions = versions
+	services.PricelistFormats = config.PricelistFormats
+	services.ListingWindow = config.ListingWindow
+	services.ListingStatus = listingStatus
+
+	// Uploads held for a batch by a previous run still time out
+	if err := services.restoreHeld(); err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		authenticator: authenticator,
 		baseURL:       config.BaseURL,
-		hostKey:       hostKey,
+		hostKeys:      hostKeys,
+		hostSigners:   hostSigners,
+		algorithms:    algorithms,
+		auditProfile:  config.Crypto.AuditProfile,
+		proxy:         config.ProxyProtocol,
 		port:          config.Port,
-	}, nil
+		services:      services,
+		users:         config.Users,
+		fileSystems:   o.fileSystems,
+		hooks:         o.hooks,
+		limits:        config.Limits,
+		timeouts:      config.Timeouts,
+		conns:         make(map[*connTracker]struct{}),
+	}
+	if s.fileSystems == nil {
+		s.fileSystems = s.apiFileSystem
+	}
+	return s, nil
 }
 
-// Start starts the SFTP server
-func (s *Server) Start() error {
-	log.Printf("Starting SFTP server on port %s", s.port)
+// apiFileSystem is the default FileSystemFactory, serving sessions from the FUTUR API
+func (s *Server) apiFileSystem(user SessionUser) FileSystem {
+	return NewAPIFileSystem(s.baseURL, user.Username, user.APIKey, user.Session, user.Settings, s.services)
+}
 
-	// Configure SSH server
-	sshConfig := &ssh.ServerConfig{
-		PasswordCallback: s.passwordCallback,
-	}
-	sshConfig.AddHostKey(s.hostKey)
+// Start listens on the configured port and serves connections until Shutdown
+// is called, then returns ErrServerClosed
+func (s *Server) Start() error {
+	s.services.logf("Starting SFTP server on port %s", s.port)
 
 	// Listen for connections
 	listener, err := net.Listen("tcp", ":"+s.port)
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %s: %w", s.port, err)
 	}
+
+	return s.Serve(context.Background(), listener)
+}
+
+// Serve accepts connections on listener until Shutdown is called or ctx ends,
+// and then returns ErrServerClosed. Ending ctx closes the open connections
+// right away; call Shutdown first to let transfers finish. The listener is
+// closed when Serve returns.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
 	defer listener.Close()
 
-	log.Printf("SFTP server listening on port %s", s.port)
+	// Configure SSH server
+	sshConfig := &ssh.ServerConfig{
+		Config: ssh.Config{
+			KeyExchanges: s.algorithms.KeyExchanges,
+			Ciphers:      s.algorithms.Ciphers,
+			MACs:         s.algorithms.MACs,
+		},
+		PasswordCallback: s.passwordCallback,
+	}
+	for _, hostKey := range s.hostSigners {
+		sshConfig.AddHostKey(hostKey)
+	}
+
+	// Connections from the load balancer report the client's address
+	if len(s.proxy.Trusted) > 0 {
+		listener = proxyproto.NewListener(listener, s.proxy)
+		s.services.logf("Reading PROXY protocol headers from %v", s.proxy.Trusted)
+	}
+
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.services.logf("SFTP server listening on %s", listener.Addr())
+	if s.hooks.OnListen != nil {
+		s.hooks.OnListen(listener.Addr())
+	}
+
+	// Ending ctx is a shutdown without grace period
+	stop := context.AfterFunc(ctx, func() {
+		expired, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Shutdown(expired)
+	})
+	defer stop()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
+			s.mu.Lock()
+			shuttingDown := s.shuttingDown
+			s.mu.Unlock()
+			if shuttingDown {
+				return ErrServerClosed
+			}
+			s.services.logf("Failed to accept connection: %v", err)
 			continue
 		}
 
@@ -79,44 +283,159 @@ func (s *Server) Start() error {
 
 func (s *Server) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
 	username := conn.User()
-	log.Printf("Authentication attempt for user: %s", username)
-
-	user, err := s.authenticator.AuthenticateUser(username, string(password))
+	s.services.logf("Authentication attempt for user %s from %s", username, conn.RemoteAddr())
+
+	var user *auth.User
+	var err error
+	if remote, ok := s.authenticator.(RemoteAuthenticator); ok {
+		user, err = remote.AuthenticateUserFrom(username, string(password), remoteIP(conn.RemoteAddr()))
+	} else {
+		user, err = s.authenticator.AuthenticateUser(username, string(password))
+	}
 	if err != nil {
-		log.Printf("Authentication failed for user %s: %v", username, err)
+		s.services.logf("Authentication failed for user %s from %s: %v", username, conn.RemoteAddr(), err)
 		return nil, fmt.Errorf("authentication failed")
 	}
 
-	log.Printf("Authentication successful for user: %s", username)
+	// The username names the user's directories in the spool, archive and pricelist store
+	if !validUsername(user.Username) {
+		s.services.logf("Authentication refused for user %s: unusable username %q", username, user.Username)
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	s.services.logf("Authentication successful for user: %s", username)
+
+	if err := s.checkUserSessions(user.Username); err != nil {
+		return nil, err
+	}
 
 	// Store username, user ID and API key in permissions for later use
+	extensions := map[string]string{
+		"username": user.Username,
+		"user_id":  user.ID,
+		"api_key":  user.ApiKey,
+	}
+
+	// Quotas from the login response override the configured ones
+	if user.Quota != nil {
+		quotaJSON, err := json.Marshal(user.Quota)
+		if err == nil {
+			extensions["quota"] = string(quotaJSON)
+		}
+	}
+
 	return &ssh.Permissions{
-		Extensions: map[string]string{
-			"username": user.Username,
-			"user_id":  user.ID,
-			"api_key":  user.ApiKey,
-		},
+		Extensions: extensions,
 	}, nil
 }
 
+// validUsername rejects names that would leave a per-user directory
+func validUsername(username string) bool {
+	return username != "" && username != "." && username != ".." && !strings.ContainsAny(username, "/\\\x00")
+}
+
 func (s *Server) handleConnection(conn net.Conn, sshConfig *ssh.ServerConfig) {
 	defer conn.Close()
 
-	// Perform SSH handshake
+	// Connections count against the limits while a proxy still sends its header
+	tracker := newConnTracker(conn)
+	if reason, message, ok := s.admit(tracker); !ok {
+		s.services.logf("Rejected connection from %s: %s", tracker, message)
+		// Refuse on the socket itself rather than wait for a PROXY header first
+		if proxied, ok := conn.(*proxyproto.Conn); ok {
+			rejectConnection(proxied.Conn, reason, message)
+		} else {
+			rejectConnection(conn, reason, message)
+		}
+		return
+	}
+	defer s.untrack(tracker)
+	defer close(tracker.gone)
+
+	// Behind a load balancer the client address comes from the PROXY header
+	if proxied, ok := conn.(*proxyproto.Conn); ok {
+		if err := proxied.ReadHeader(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.services.logf("Dropped connection from proxy %s: %v", proxied.ProxyAddr(), err)
+			}
+			return
+		}
+		if reason, message, ok := s.admitAddress(tracker, conn.RemoteAddr()); !ok {
+			s.services.logf("Rejected connection from %s: %s", conn.RemoteAddr(), message)
+			rejectConnection(conn, reason, message)
+			return
+		}
+	}
+
+	if s.hooks.OnConnect != nil {
+		s.hooks.OnConnect(conn.RemoteAddr())
+	}
+
+	// Perform SSH handshake, clients that do not log in in time are disconnected
+	if s.timeouts.Handshake > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeouts.Handshake))
+	}
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, sshConfig)
 	if err != nil {
-		log.Printf("SSH handshake failed: %v", err)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.services.logf("SSH handshake from %s did not finish within %s", conn.RemoteAddr(), s.timeouts.Handshake)
+		} else {
+			s.services.logf("SSH handshake failed: %v", err)
+		}
 		return
 	}
 	defer sshConn.Close()
+	conn.SetDeadline(time.Time{})
 
 	// Get username and API key from permissions
 	username := sshConn.Permissions.Extensions["username"]
-	apiKey := sshConn.Permissions.Extensions["api_key"]
-	log.Printf("New SSH connection from %s for user %s", conn.RemoteAddr(), username)
+	s.services.logf("New SSH connection from %s for user %s", conn.RemoteAddr(), username)
+	s.logAlgorithms(sshConn, username)
+	tracker.setUser(username)
+	tracker.touch()
+
+	// Logins that raced past the check in passwordCallback
+	if max := s.limits.MaxSessionsPerUser; max > 0 && s.userSessions(username) > max {
+		s.services.logf("Closing connection of %s: session limit %d exceeded", username, max)
+		return
+	}
 
-	// Handle global requests
-	go ssh.DiscardRequests(reqs)
+	settings := s.users[username]
+	if quotaJSON, ok := sshConn.Permissions.Extensions["quota"]; ok {
+		var limits quota.Limits
+		if err := json.Unmarshal([]byte(quotaJSON), &limits); err == nil {
+			settings.Quota = &limits
+		}
+	}
+
+	user := SessionUser{
+		Username: username,
+		UserID:   sshConn.Permissions.Extensions["user_id"],
+		APIKey:   sshConn.Permissions.Extensions["api_key"],
+		Session: Session{
+			ID:            hex.EncodeToString(sshConn.SessionID()),
+			RemoteAddr:    conn.RemoteAddr().String(),
+			ClientVersion: string(sshConn.ClientVersion()),
+		},
+		Settings: settings,
+	}
+
+	if s.hooks.OnLogin != nil {
+		s.hooks.OnLogin(user)
+	}
+	if s.hooks.OnDisconnect != nil {
+		defer s.hooks.OnDisconnect(user)
+	}
+
+	// Handle global requests and tell the client about all host keys
+	go s.handleGlobalRequests(sshConn.Conn, reqs)
+	if err := s.hostKeys.announce(sshConn); err != nil {
+		s.services.logf("Failed to announce host keys to %s: %v", username, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.superviseConnection(sshConn, tracker, done)
 
 	// Handle channels
 	for newChannel := range chans {
@@ -124,21 +443,28 @@ func (s *Server) handleConnection(conn net.Conn, sshConfig *ssh.ServerConfig) {
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			continue
 		}
+		if !s.allowChannel(tracker) {
+			s.services.logf("Rejected channel of %s: limit of %d channels reached", username, s.limits.MaxChannelsPerConnection)
+			newChannel.Reject(ssh.ResourceShortage, "too many channels")
+			continue
+		}
 
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			log.Printf("Failed to accept channel: %v", err)
+			s.services.logf("Failed to accept channel: %v", err)
 			continue
 		}
+		tracker.addChannel(channel)
 
 		// Handle channel requests
 		go func(in <-chan *ssh.Request) {
+			defer tracker.removeChannel(channel)
 			for req := range in {
 				switch req.Type {
 				case "subsystem":
 					if string(req.Payload[4:]) == "sftp" {
 						req.Reply(true, nil)
-						s.handleSFTP(channel, username, apiKey)
+						s.handleSFTP(channel, tracker, user)
 					} else {
 						req.Reply(false, nil)
 					}
@@ -150,88 +476,50 @@ func (s *Server) handleConnection(conn net.Conn, sshConfig *ssh.ServerConfig) {
 	}
 }
 
-func (s *Server) handleSFTP(channel ssh.Channel, username, apiKey string) {
+// handleGlobalRequests answers hostkeys-prove requests and refuses all others
+func (s *Server) handleGlobalRequests(conn ssh.Conn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case hostKeysProveRequest:
+			proof, err := s.hostKeys.prove(conn, req.Payload)
+			if err != nil {
+				s.services.logf("Failed to prove host keys: %v", err)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, proof)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (s *Server) handleSFTP(channel ssh.Channel, tracker *connTracker, user SessionUser) {
 	defer channel.Close()
 
-	log.Printf("Starting SFTP session for user: %s", username)
+	s.services.logf("Starting SFTP session for user: %s", user.Username)
 
-	// Create API-backed file system for the user
-	filesystem := NewAPIFileSystem(s.baseURL, username, apiKey)
+	// Create the file system for the user, API-backed unless a factory was given
+	filesystem := s.fileSystems(user)
+	tracked := trackedFileSystem{FileSystem: filesystem, conn: tracker, user: user, hooks: s.hooks}
 
-	// Create handlers
+	// Create handlers, transfers are tracked so that Shutdown can wait for them
 	handlers := sftp.Handlers{
-		FileGet:  filesystem,
-		FilePut:  filesystem,
+		FileGet:  tracked,
+		FilePut:  tracked,
 		FileCmd:  filesystem,
 		FileList: filesystem,
 	}
 
 	// Create SFTP request server
-	requestServer := sftp.NewRequestServer(channel, handlers)
+	requestServer := sftp.NewRequestServer(activityChannel{Channel: channel, conn: tracker}, handlers)
 
 	// Serve SFTP requests
 	if err := requestServer.Serve(); err != nil && err != io.EOF {
-		log.Printf("SFTP server error: %v", err)
-	}
-
-	log.Printf("SFTP session ended for user: %s", username)
-}
-
-// loadOrCreateHostKey loads an existing host key or creates a new one
-func loadOrCreateHostKey(hostKeyPath string) (ssh.Signer, error) {
-	// Try to load existing key
-	if _, err := os.Stat(hostKeyPath); err == nil {
-		keyData, err := os.ReadFile(hostKeyPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read host key: %w", err)
-		}
-
-		key, err := ssh.ParsePrivateKey(keyData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse host key: %w", err)
-		}
-
-		log.Printf("Loaded existing host key from %s", hostKeyPath)
-		return key, nil
-	}
-
-	// Create new key
-	log.Printf("Creating new host key at %s", hostKeyPath)
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
-	}
-
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal private key: %w", err)
-	}
-
-	privateKeyPEM := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	}
-
-	keyFile, err := os.Create(hostKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create host key file: %w", err)
-	}
-	defer keyFile.Close()
-
-	if err := pem.Encode(keyFile, privateKeyPEM); err != nil {
-		return nil, fmt.Errorf("failed to write host key: %w", err)
-	}
-
-	// Set proper permissions
-	if err := os.Chmod(hostKeyPath, 0600); err != nil {
-		return nil, fmt.Errorf("failed to set host key permissions: %w", err)
-	}
-
-	signer, err := ssh.NewSignerFromKey(privateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create signer from key: %w", err)
+		s.services.logf("SFTP server error: %v", err)
 	}
 
-	return signer, nil
+	s.services.logf("SFTP session ended for user: %s", user.Username)
 }
@@ -0,0 +1,411 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sftp-service/internal/proxyproto"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrServerClosed is returned by Start once Shutdown has been called
+var ErrServerClosed = errors.New("sftp server closed")
+
+// shutdownMessage is written to the stderr of sessions that are closed by Shutdown
+const shutdownMessage = "The server is restarting, please reconnect in a moment.\r\n"
+
+// disconnectGrace is how long a disconnected client gets to close its end of
+// the connection after its sessions were closed
+const disconnectGrace = 2 * time.Second
+
+// errShuttingDown refuses transfers opened while the server drains
+var errShuttingDown = errors.New("server is shutting down, please reconnect")
+
+// connTracker follows one client connection and its open transfers, so that
+// Shutdown can close idle sessions and wait for busy ones
+type connTracker struct {
+	netConn net.Conn
+	ip      string // Client address, empty while a PROXY header is being read; guarded by Server.mu
+
+	mu        sync.Mutex
+	addr      net.Addr // Client address, or the proxy's until its header was read
+	username  string
+	channels  []ssh.Channel
+	transfers map[int]string // Open transfers by ID
+	nextID    int
+	draining  bool
+	active    time.Time     // Last SFTP request or transfer end, see idleFor
+	gone      chan struct{} // Closed once handleConnection is done with the connection
+}
+
+func newConnTracker(conn net.Conn) *connTracker {
+	tracker := &connTracker{
+		netConn:   conn,
+		transfers: make(map[int]string),
+		active:    time.Now(),
+		gone:      make(chan struct{}),
+	}
+
+	// RemoteAddr of a proxied connection waits for its header
+	if proxied, ok := conn.(*proxyproto.Conn); ok {
+		tracker.addr = proxied.ProxyAddr()
+	} else {
+		tracker.addr = conn.RemoteAddr()
+		tracker.ip = remoteIP(tracker.addr)
+	}
+	return tracker
+}
+
+// setAddr replaces the proxy's address with the client's from the PROXY header
+func (c *connTracker) setAddr(addr net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addr = addr
+}
+
+// begin registers a transfer. The returned func ends it.
+func (c *connTracker) begin(description string) (func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return nil, errShuttingDown
+	}
+
+	id := c.nextID
+	c.nextID++
+	c.transfers[id] = description
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.transfers, id)
+		c.active = time.Now()
+	}, nil
+}
+
+// addChannel remembers a session channel for the disconnect message
+func (c *connTracker) addChannel(channel ssh.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels = append(c.channels, channel)
+}
+
+// removeChannel forgets a closed session channel
+func (c *connTracker) removeChannel(channel ssh.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.channels {
+		if other == channel {
+			c.channels = append(c.channels[:i], c.channels[i+1:]...)
+			return
+		}
+	}
+}
+
+// channelCount returns the number of open session channels
+func (c *connTracker) channelCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels)
+}
+
+// setUser records the authenticated user of the connection
+func (c *connTracker) setUser(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+}
+
+// user returns the authenticated user, empty before authentication
+func (c *connTracker) user() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.username
+}
+
+// drain refuses new transfers and reports whether the connection is idle
+func (c *connTracker) drain() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	return len(c.transfers) == 0
+}
+
+// open returns the descriptions of the open transfers
+func (c *connTracker) open() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var open []string
+	for _, description := range c.transfers {
+		open = append(open, description)
+	}
+	sort.Strings(open)
+	return open
+}
+
+// touch records activity on the connection
+func (c *connTracker) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = time.Now()
+}
+
+// idleFor returns how long the connection has had no requests and no open transfers
+func (c *connTracker) idleFor() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.transfers) > 0 {
+		return 0
+	}
+	return time.Since(c.active)
+}
+
+// disconnect ends the client's sessions the way a finished session ends: the
+// reason on stderr, then end of file and the channel close, so that clients
+// report the message and a closed connection rather than a dropped one.
+// x/crypto/ssh cannot send SSH_MSG_DISCONNECT after the handshake, so the
+// socket is closed once the client hung up or disconnectGrace passed. It
+// returns at once, a client that stopped reading cannot hold up the caller.
+func (c *connTracker) disconnect(message string) {
+	c.mu.Lock()
+	channels := c.channels
+	c.mu.Unlock()
+
+	go func() {
+		defer c.netConn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), disconnectGrace)
+		defer cancel()
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for _, channel := range channels {
+				if message != "" {
+					channel.Stderr().Write([]byte(message))
+				}
+				channel.CloseWrite()
+				channel.Close()
+			}
+		}()
+
+		select {
+		case <-closed:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-c.gone:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// drop closes the connection of a client that no longer answers
+func (c *connTracker) drop() {
+	c.netConn.Close()
+}
+
+func (c *connTracker) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.username == "" {
+		return c.addr.String()
+	}
+	return fmt.Sprintf("%s (%s)", c.username, c.addr)
+}
+
+func (s *Server) untrack(conn *connTracker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// Shutdown stops accepting connections and drains the open ones: idle sessions
+// are told to reconnect and closed, busy sessions are closed as soon as their
+// transfers and order deliveries have finished. When ctx ends first, the
+// remaining connections are closed and their open transfers reported.
+func (s *Server) Shutdown(ctx context.Context) error {
+	started := time.Now()
+	s.mu.Lock()
+	s.shuttingDown = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	// A connection is closed once it was idle on two polls in a row, which
+	// leaves time for the reply to the client's last close request
+	idle := make(map[*connTracker]bool)
+	for {
+		s.mu.Lock()
+		for conn := range s.conns {
+			if !conn.drain() {
+				idle[conn] = false
+				continue
+			}
+			if idle[conn] {
+				s.services.logf("Closing idle connection of %s", conn)
+				conn.disconnect(shutdownMessage)
+				delete(s.conns, conn)
+				continue
+			}
+			idle[conn] = true
+		}
+		remaining := len(s.conns)
+		s.mu.Unlock()
+
+		if remaining == 0 {
+			s.services.logf("All SFTP sessions closed")
+			s.shutdownDone(nil, started)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			aborted := s.abort()
+			s.shutdownDone(aborted, started)
+			if len(aborted) == 0 {
+				return nil
+			}
+			return fmt.Errorf("shutdown aborted %d transfers (%v): %s", len(aborted), ctx.Err(), strings.Join(aborted, "; "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// abort closes the remaining connections and returns their unfinished transfers
+func (s *Server) abort() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var aborted []string
+	for conn := range s.conns {
+		for _, transfer := range conn.open() {
+			aborted = append(aborted, fmt.Sprintf("%s: %s", conn, transfer))
+		}
+		conn.disconnect(shutdownMessage)
+		delete(s.conns, conn)
+	}
+
+	for _, transfer := range aborted {
+		s.services.logf("Aborted by shutdown: %s", transfer)
+	}
+	return aborted
+}
+
+// shutdownDone reports the end of a shutdown to the OnShutdown hook
+func (s *Server) shutdownDone(aborted []string, started time.Time) {
+	if s.hooks.OnShutdown != nil {
+		s.hooks.OnShutdown(aborted, time.Since(started))
+	}
+}
+
+// trackedFileSystem registers every opened file with its connection
+type trackedFileSystem struct {
+	FileSystem
+	conn  *connTracker
+	user  SessionUser
+	hooks Hooks
+}
+
+func (t trackedFileSystem) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	done, err := t.begin("download", r.Filepath, false)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := t.FileSystem.Fileread(r)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &trackedReaderAt{ReaderAt: reader, done: done}, nil
+}
+
+func (t trackedFileSystem) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	done, err := t.begin("upload", r.Filepath, true)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := t.FileSystem.Filewrite(r)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &trackedWriterAt{WriterAt: writer, done: done}, nil
+}
+
+// begin registers a transfer. The returned func ends it and reports it to the OnTransfer hook.
+func (t trackedFileSystem) begin(kind, filePath string, upload bool) (func(error), error) {
+	end, err := t.conn.begin(kind + " " + filePath)
+	if err != nil {
+		return nil, err
+	}
+	return func(err error) {
+		end()
+		if t.hooks.OnTransfer != nil {
+			t.hooks.OnTransfer(TransferInfo{
+				Username: t.user.Username,
+				Session:  t.user.Session,
+				Path:     filePath,
+				Upload:   upload,
+				Err:      err,
+			})
+		}
+	}, nil
+}
+
+// trackedReaderAt ends its transfer once the file is closed
+type trackedReaderAt struct {
+	io.ReaderAt
+	done func(error)
+}
+
+func (r *trackedReaderAt) Close() error {
+	var err error
+	if closer, ok := r.ReaderAt.(io.Closer); ok {
+		err = closer.Close()
+	}
+	r.done(err)
+	return err
+}
+
+func (r *trackedReaderAt) TransferError(err error) {
+	if t, ok := r.ReaderAt.(sftp.TransferError); ok {
+		t.TransferError(err)
+	}
+}
+
+// trackedWriterAt ends its transfer once the file is closed and delivered
+type trackedWriterAt struct {
+	io.WriterAt
+	done func(error)
+}
+
+func (w *trackedWriterAt) Close() error {
+	var err error
+	if closer, ok := w.WriterAt.(io.Closer); ok {
+		err = closer.Close()
+	}
+	w.done(err)
+	return err
+}
+
+func (w *trackedWriterAt) TransferError(err error) {
+	if t, ok := w.WriterAt.(sftp.TransferError); ok {
+		t.TransferError(err)
+	}
+}
@@ -0,0 +1,124 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// streamWindow is how much already-read data is kept so that reads arriving
+// slightly out of order (the SFTP server handles packets concurrently) can be
+// served without restarting the download
+const streamWindow = 4 * 1024 * 1024
+
+// streamReaderAt implements io.ReaderAt on top of a sequential stream that can
+// be reopened at an arbitrary offset
+type streamReaderAt struct {
+	open func(offset int64) (io.ReadCloser, error)
+
+	mu       sync.Mutex
+	body     io.ReadCloser
+	opened   int64 // offset body was opened at
+	pos      int64 // offset of the next byte read from body
+	buf      []byte
+	bufStart int64 // offset of buf[0]
+	eof      bool
+}
+
+func (r *streamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Outside the window, restart the stream at the requested offset
+	if r.body == nil || off < r.bufStart || off > r.pos+streamWindow {
+		if err := r.reopen(off); err != nil {
+			return 0, err
+		}
+	}
+
+	// Fill the buffer until it covers the requested range or the stream ends
+	end := off + int64(len(p))
+	for r.pos < end && !r.eof {
+		chunk := make([]byte, 32*1024)
+		n, err := r.body.Read(chunk)
+		r.buf = append(r.buf, chunk[:n]...)
+		r.pos += int64(n)
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			// A download that timed out after making progress continues where it stopped
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() || r.pos == r.opened {
+				return 0, err
+			}
+			if err := r.resume(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n := 0
+	if off < r.pos {
+		n = copy(p, r.buf[off-r.bufStart:])
+	}
+
+	// Drop data that is far enough behind to be no longer needed
+	if excess := int64(len(r.buf)) - streamWindow; excess > 0 {
+		r.buf = append([]byte(nil), r.buf[excess:]...)
+		r.bufStart += excess
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *streamReaderAt) reopen(off int64) error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	r.pos = off
+	r.buf = nil
+	r.bufStart = off
+	r.eof = false
+	return r.openAtPos()
+}
+
+// resume reopens the stream where it stopped, keeping the buffer
+func (r *streamReaderAt) resume() error {
+	r.body.Close()
+	r.body = nil
+	return r.openAtPos()
+}
+
+// openAtPos opens the stream at pos. At the end of the file the stream is empty.
+func (r *streamReaderAt) openAtPos() error {
+	body, err := r.open(r.pos)
+	if err == io.EOF {
+		body, err = io.NopCloser(strings.NewReader("")), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.body = body
+	r.opened = r.pos
+	return nil
+}
+
+func (r *streamReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.body != nil {
+		err := r.body.Close()
+		r.body = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// timeoutError is what a read of a response body returns when the client timeout expires
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "Client.Timeout exceeded while reading body" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// cutReader returns at most limit bytes and then fails with err
+type cutReader struct {
+	data  []byte
+	limit int
+	err   error
+}
+
+func (r *cutReader) Read(p []byte) (int, error) {
+	if r.limit == 0 || len(r.data) == 0 {
+		if len(r.data) == 0 {
+			return 0, io.EOF
+		}
+		return 0, r.err
+	}
+	n := copy(p, r.data[:min(len(r.data), r.limit)])
+	r.data = r.data[n:]
+	r.limit -= n
+	return n, nil
+}
+
+func TestStreamReaderAt(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	tests := []struct {
+		name    string
+		limit   int   // Bytes each opened stream delivers before failing
+		err     error // Failure after limit bytes
+		wantErr error
+	}{
+		{"whole stream", len(content), nil, nil},
+		{"resumed after timeouts", 25000, timeoutError{}, nil},
+		{"timeout without progress", 0, timeoutError{}, timeoutError{}},
+		{"other errors end the read", 25000, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var offsets []int64
+			r := &streamReaderAt{open: func(offset int64) (io.ReadCloser, error) {
+				offsets = append(offsets, offset)
+				if offset >= int64(len(content)) {
+					return nil, io.EOF
+				}
+				return io.NopCloser(&cutReader{data: content[offset:], limit: tt.limit, err: tt.err}), nil
+			}}
+			defer r.Close()
+
+			var got []byte
+			buf := make([]byte, 32*1024)
+			for off := int64(0); ; {
+				n, err := r.ReadAt(buf, off)
+				got = append(got, buf[:n]...)
+				off += int64(n)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if tt.wantErr == nil || !errors.Is(err, tt.wantErr) {
+						t.Fatalf("ReadAt(%d) error = %v, want %v", off, err, tt.wantErr)
+					}
+					return
+				}
+			}
+			if tt.wantErr != nil {
+				t.Fatalf("read succeeded, want %v", tt.wantErr)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("read %d bytes, want the %d of the stream", len(got), len(content))
+			}
+			if tt.err != nil && len(offsets) < 2 {
+				t.Errorf("stream opened at %v, want resumed", offsets)
+			}
+		})
+	}
+}
+
+func TestStreamReaderAtEnd(t *testing.T) {
+	r := &streamReaderAt{open: func(offset int64) (io.ReadCloser, error) {
+		// The API answers 416 for a range starting at the end
+		return nil, io.EOF
+	}}
+	if n, err := r.ReadAt(make([]byte, 10), 100); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v; want 0, EOF", n, err)
+	}
+}
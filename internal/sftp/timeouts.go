@@ -0,0 +1,98 @@
+package sftp
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Timeouts bound how long a connection may take to log in and stay open. Zero disables each.
+type Timeouts struct {
+	Handshake         time.Duration // Key exchange and authentication
+	Idle              time.Duration // No SFTP requests and no open transfers
+	MaxSession        time.Duration // Total lifetime of an authenticated connection
+	KeepaliveInterval time.Duration // Between keepalive@openssh.com requests
+	KeepaliveCountMax int           // Unanswered keepalives before the peer is considered dead, zero for no limit
+}
+
+// keepaliveRequest is the global request OpenSSH uses for keepalives. Clients
+// answer it with a failure, which still proves that the peer is alive.
+const keepaliveRequest = "keepalive@openssh.com"
+
+// superviseConnection enforces the idle timeout, the maximum session duration
+// and keepalives of an authenticated connection until done is closed
+func (s *Server) superviseConnection(sshConn ssh.Conn, conn *connTracker, done <-chan struct{}) {
+	var lifetime, idleCheck, keepalive <-chan time.Time
+	if s.timeouts.MaxSession > 0 {
+		timer := time.NewTimer(s.timeouts.MaxSession)
+		defer timer.Stop()
+		lifetime = timer.C
+	}
+	if s.timeouts.Idle > 0 {
+		ticker := time.NewTicker(min(s.timeouts.Idle/4, time.Second))
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+	if s.timeouts.KeepaliveInterval > 0 {
+		ticker := time.NewTicker(s.timeouts.KeepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	var (
+		reply  chan error // Set while a keepalive is unanswered
+		missed int
+	)
+	for {
+		select {
+		case <-done:
+			return
+		case <-lifetime:
+			s.services.logf("Closing connection of %s: maximum session duration of %s reached", conn, s.timeouts.MaxSession)
+			conn.disconnect(fmt.Sprintf("Maximum session duration of %s reached, please reconnect.\r\n", s.timeouts.MaxSession))
+			return
+		case <-idleCheck:
+			if conn.idleFor() >= s.timeouts.Idle {
+				s.services.logf("Closing connection of %s: idle timeout of %s reached", conn, s.timeouts.Idle)
+				conn.disconnect(fmt.Sprintf("Idle timeout of %s reached, disconnecting.\r\n", s.timeouts.Idle))
+				return
+			}
+		case err := <-reply:
+			reply = nil
+			if err != nil {
+				return // The connection closed
+			}
+			missed = 0
+		case <-keepalive:
+			if reply != nil {
+				missed++
+				if max := s.timeouts.KeepaliveCountMax; max > 0 && missed >= max {
+					s.services.logf("Closing connection of %s: no answer to %d keepalives", conn, missed)
+					conn.drop()
+					return
+				}
+				continue
+			}
+			reply = make(chan error, 1)
+			go func(reply chan<- error) {
+				_, _, err := sshConn.SendRequest(keepaliveRequest, true, nil)
+				reply <- err
+			}(reply)
+		}
+	}
+}
+
+// activityChannel records every SFTP request read from the channel as activity
+type activityChannel struct {
+	ssh.Channel
+	conn *connTracker
+}
+
+func (c activityChannel) Read(data []byte) (int, error) {
+	n, err := c.Channel.Read(data)
+	if n > 0 {
+		c.conn.touch()
+	}
+	return n, err
+}
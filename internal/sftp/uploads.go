@@ -0,0 +1,116 @@
+package sftp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"sftp-service/internal/spool"
+)
+
+// How the delivery status of recent uploads is shown in /in listings
+const (
+	ListingStatusSuffix = "suffix" // order.csv.delivered
+	ListingStatusFiles  = "files"  // order.csv plus a readable order.csv.status
+)
+
+const statusFileSuffix = ".status"
+
+// recentUploads returns the latest upload of each name in folder within the listing window
+func (fs *APIFileSystem) recentUploads(folder string) []spool.Entry {
+	if fs.services.Spool == nil || fs.services.ListingWindow <= 0 {
+		return nil
+	}
+
+	since := fs.services.now().Add(-fs.services.ListingWindow)
+	seen := make(map[string]bool)
+	var latest []spool.Entry
+	for _, entry := range fs.services.Spool.Recent(fs.username, folder, since) {
+		if seen[entry.Name] {
+			continue
+		}
+		seen[entry.Name] = true
+		latest = append(latest, entry)
+	}
+	return latest
+}
+
+// uploadFileInfos returns the listing entries for recent uploads in folder
+func (fs *APIFileSystem) uploadFileInfos(folder string) []os.FileInfo {
+	var fileInfos []os.FileInfo
+	for _, entry := range fs.recentUploads(folder) {
+		if fs.services.ListingStatus == ListingStatusFiles {
+			fileInfos = append(fileInfos, &apiFileInfo{
+				name:    entry.Name,
+				size:    entry.Size,
+				modTime: entry.Time,
+				mode:    0200, // Content stays write-only
+			})
+			fileInfos = append(fileInfos, &apiFileInfo{
+				name:    entry.Name + statusFileSuffix,
+				size:    int64(len(uploadStatusText(entry))),
+				modTime: entry.Time,
+				mode:    0444,
+			})
+			continue
+		}
+
+		fileInfos = append(fileInfos, &apiFileInfo{
+			name:    entry.Name + "." + entry.Status,
+			size:    entry.Size,
+			modTime: entry.Time,
+			mode:    0200,
+		})
+	}
+	return fileInfos
+}
+
+// findUpload looks up the recent upload behind a listed path. isStatus is set
+// when the path is the companion .status file.
+func (fs *APIFileSystem) findUpload(filePath string) (entry spool.Entry, isStatus bool, ok bool) {
+	folder := path.Dir(filePath)
+	name := path.Base(filePath)
+
+	for _, entry := range fs.recentUploads(folder) {
+		if fs.services.ListingStatus == ListingStatusFiles {
+			if name == entry.Name {
+				return entry, false, true
+			}
+			if name == entry.Name+statusFileSuffix {
+				return entry, true, true
+			}
+		} else if name == entry.Name+"."+entry.Status {
+			return entry, false, true
+		}
+	}
+	return spool.Entry{}, false, false
+}
+
+// uploadFileInfo returns the listing entry for a single recent upload path
+func (fs *APIFileSystem) uploadFileInfo(filePath string) (os.FileInfo, bool) {
+	name := path.Base(filePath)
+	for _, fileInfo := range fs.uploadFileInfos(path.Dir(filePath)) {
+		if fileInfo.Name() == name {
+			return fileInfo, true
+		}
+	}
+	return nil, false
+}
+
+// uploadStatusText is the content of a .status companion file
+func uploadStatusText(entry spool.Entry) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "file: %s\n", entry.Name)
+	if entry.Original != "" && entry.Original != entry.Name {
+		fmt.Fprintf(&b, "uploaded as: %s\n", entry.Original)
+	}
+	fmt.Fprintf(&b, "status: %s\n", entry.Status)
+	fmt.Fprintf(&b, "size: %d\n", entry.Size)
+	fmt.Fprintf(&b, "time: %s\n", entry.Time.UTC().Format(time.RFC3339))
+	if entry.Reason != "" {
+		fmt.Fprintf(&b, "reason: %s\n", entry.Reason)
+	}
+	return []byte(b.String())
+}
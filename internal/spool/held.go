@@ -0,0 +1,133 @@
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// HeldFile is an upload waiting in the spool for its batch to be committed
+type HeldFile struct {
+	Entry    Entry           `json:"entry"`              // Journal entry of the upload
+	Data     []byte          `json:"data"`               // Normalized content, ready for delivery
+	Metadata json.RawMessage `json:"metadata,omitempty"` // Upload metadata sent with the batch
+}
+
+// Hold stores an upload until its batch is committed or times out. With a
+// spool directory held files survive restarts.
+func (s *Spool) Hold(username string, file HeldFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir != "" {
+		dir := s.heldDir(username)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create held file directory: %w", err)
+		}
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.heldPath(username, file.Entry.ID), data, 0600); err != nil {
+			return fmt.Errorf("failed to hold upload: %w", err)
+		}
+	}
+
+	held := s.held[username]
+	if held == nil {
+		held = make(map[string]HeldFile)
+		s.held[username] = held
+	}
+	held[file.Entry.ID] = file
+	return nil
+}
+
+// Held returns the user's held files in folder, oldest first
+func (s *Spool) Held(username, folder string) []HeldFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []HeldFile
+	for _, file := range s.held[username] {
+		if file.Entry.Folder == folder {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Entry.Time.Before(files[j].Entry.Time) })
+	return files
+}
+
+// TakeHeld removes a held file and returns it, false if it was already taken
+func (s *Spool) TakeHeld(username, id string) (HeldFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.held[username][id]
+	if !ok {
+		return HeldFile{}, false
+	}
+	delete(s.held[username], id)
+
+	if s.dir != "" {
+		if err := os.Remove(s.heldPath(username, id)); err != nil && !os.IsNotExist(err) {
+			s.logf("Failed to remove held file %s for %s: %v", id, username, err)
+		}
+	}
+	return file, true
+}
+
+// LoadHeld reads the files held on disk by a previous run, keyed by username
+func (s *Spool) LoadHeld() (map[string][]HeldFile, error) {
+	loaded := make(map[string][]HeldFile)
+	if s.dir == "" {
+		return loaded, nil
+	}
+
+	users, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range users {
+		if !user.IsDir() {
+			continue
+		}
+		username := user.Name()
+		paths, _ := filepath.Glob(filepath.Join(s.heldDir(username), "*.json"))
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				s.logf("Failed to read held file %s: %v", p, err)
+				continue
+			}
+			var file HeldFile
+			if err := json.Unmarshal(data, &file); err != nil {
+				s.logf("Failed to parse held file %s: %v", p, err)
+				continue
+			}
+
+			held := s.held[username]
+			if held == nil {
+				held = make(map[string]HeldFile)
+				s.held[username] = held
+			}
+			held[file.Entry.ID] = file
+			loaded[username] = append(loaded[username], file)
+		}
+	}
+	return loaded, nil
+}
+
+func (s *Spool) heldDir(username string) string {
+	return filepath.Join(s.dir, filepath.Base(username), "held")
+}
+
+func (s *Spool) heldPath(username, id string) string {
+	return filepath.Join(s.heldDir(username), url.PathEscape(id)+".json")
+}
@@ -0,0 +1,202 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AreaRejected is the user's dead-letter area for uploads refused by the API
+const AreaRejected = "rejected"
+
+// ReasonSuffix is appended to a rejected file's name for the file explaining the rejection
+const ReasonSuffix = ".reason.txt"
+
+// File is a file in one of a user's spool areas
+type File struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// maxMemoryFiles bounds each area of a user when files are kept in memory
+const maxMemoryFiles = 200
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Reject stores a rejected upload in the user's dead-letter area together
+// with a reason file. It returns the stored name.
+func (s *Spool) Reject(username, name string, data, reason []byte, at time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.uniqueNameLocked(AreaRejected, username, name, at)
+	if err := s.writeFileLocked(AreaRejected, username, stored, data, at); err != nil {
+		return "", fmt.Errorf("failed to store rejected file: %w", err)
+	}
+	if err := s.writeFileLocked(AreaRejected, username, stored+ReasonSuffix, reason, at); err != nil {
+		return "", fmt.Errorf("failed to store rejection reason: %w", err)
+	}
+	return stored, nil
+}
+
+// Files lists one of the user's areas, sorted by name
+func (s *Spool) Files(area, username string) ([]File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []File
+	if s.dir == "" {
+		for name, file := range s.files[area+"\x00"+username] {
+			files = append(files, File{Name: name, Size: int64(len(file.data)), ModTime: file.modTime})
+		}
+	} else {
+		entries, err := os.ReadDir(s.areaDir(area, username))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s directory: %w", area, err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			files = append(files, File{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// StatFile returns a single file of one of the user's areas
+func (s *Spool) StatFile(area, username, name string) (File, error) {
+	files, err := s.Files(area, username)
+	if err != nil {
+		return File{}, err
+	}
+	for _, file := range files {
+		if file.Name == name {
+			return file, nil
+		}
+	}
+	return File{}, os.ErrNotExist
+}
+
+// ReadFile returns the content of a file in one of the user's areas
+func (s *Spool) ReadFile(area, username, name string) ([]byte, error) {
+	if !validFileName(name) {
+		return nil, os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		file, ok := s.files[area+"\x00"+username][name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return file.data, nil
+	}
+	return os.ReadFile(filepath.Join(s.areaDir(area, username), name))
+}
+
+// RemoveFile deletes a file from one of the user's areas
+func (s *Spool) RemoveFile(area, username, name string) error {
+	if !validFileName(name) {
+		return os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		files := s.files[area+"\x00"+username]
+		if _, ok := files[name]; !ok {
+			return os.ErrNotExist
+		}
+		delete(files, name)
+		return nil
+	}
+	return os.Remove(filepath.Join(s.areaDir(area, username), name))
+}
+
+// uniqueNameLocked prefixes name with the time so repeated files with the same name are kept apart
+func (s *Spool) uniqueNameLocked(area, username, name string, at time.Time) string {
+	stamp := at.UTC().Format("20060102T150405Z")
+	stored := stamp + "_" + filepath.Base(name)
+	for i := 1; s.existsLocked(area, username, stored); i++ {
+		stored = fmt.Sprintf("%s_%d_%s", stamp, i, filepath.Base(name))
+	}
+	return stored
+}
+
+func (s *Spool) existsLocked(area, username, name string) bool {
+	if s.dir == "" {
+		_, ok := s.files[area+"\x00"+username][name]
+		return ok
+	}
+	_, err := os.Stat(filepath.Join(s.areaDir(area, username), name))
+	return err == nil
+}
+
+func (s *Spool) writeFileLocked(area, username, name string, data []byte, at time.Time) error {
+	if s.dir == "" {
+		key := area + "\x00" + username
+		if s.files[key] == nil {
+			s.files[key] = make(map[string]memoryFile)
+		}
+		s.files[key][name] = memoryFile{data: data, modTime: at}
+		s.pruneFilesLocked(key, at)
+		return nil
+	}
+
+	dir := s.areaDir(area, username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// pruneFilesLocked drops in-memory files older than the retention and the
+// oldest ones beyond maxMemoryFiles
+func (s *Spool) pruneFilesLocked(key string, now time.Time) {
+	files := s.files[key]
+	names := make([]string, 0, len(files))
+	for name, file := range files {
+		if s.retention > 0 && file.modTime.Before(now.Add(-s.retention)) {
+			delete(files, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) <= maxMemoryFiles {
+		return
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, b := files[names[i]], files[names[j]]
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names[:len(names)-maxMemoryFiles] {
+		delete(files, name)
+	}
+}
+
+func (s *Spool) areaDir(area, username string) string {
+	return filepath.Join(s.dir, filepath.Base(username), area)
+}
+
+// validFileName keeps lookups inside an area
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,21 @@
+package spool
+
+import (
+	"fmt"
+	"time"
+)
+
+// AreaReports is the user's area for dry-run validation reports
+const AreaReports = "reports"
+
+// AddReport stores a report in the user's report area and returns its stored name
+func (s *Spool) AddReport(username, name string, data []byte, at time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.uniqueNameLocked(AreaReports, username, name, at)
+	if err := s.writeFileLocked(AreaReports, username, stored, data, at); err != nil {
+		return "", fmt.Errorf("failed to store report: %w", err)
+	}
+	return stored, nil
+}
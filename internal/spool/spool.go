@@ -0,0 +1,291 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upload statuses shown to users
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Entry records an upload and its delivery status
+type Entry struct {
+	ID       string    `json:"id"`
+	Folder   string    `json:"folder"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"`
+	Reason   string    `json:"reason,omitempty"`
+	Original string    `json:"original,omitempty"` // Name as uploaded, when it was changed by the naming policy
+}
+
+// Spool keeps a journal of uploads per user. With a directory the journal is
+// stored as <dir>/<user>/uploads.jsonl and survives restarts, otherwise it is
+// kept in memory only.
+type Spool struct {
+	dir       string
+	retention time.Duration
+	logger    *log.Logger      // Standard logger when nil
+	now       func() time.Time // Time source for pruning
+
+	mu      sync.Mutex
+	entries map[string][]Entry // Latest version of each entry per user, oldest first
+	loaded  map[string]bool
+	appends map[string]int // Lines appended since the journal was last compacted
+
+	files map[string]map[string]memoryFile // Area files per area and user when kept in memory
+	held  map[string]map[string]HeldFile   // Uploads waiting for their batch, per user and ID
+}
+
+// New creates a spool. Entries older than retention are dropped.
+func New(dir string, retention time.Duration) (*Spool, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+	}
+
+	return &Spool{
+		dir:       dir,
+		retention: retention,
+		now:       time.Now,
+		entries:   make(map[string][]Entry),
+		loaded:    make(map[string]bool),
+		appends:   make(map[string]int),
+		files:     make(map[string]map[string]memoryFile),
+		held:      make(map[string]map[string]HeldFile),
+	}, nil
+}
+
+// SetLogger sends the spool's log output to logger instead of the standard logger
+func (s *Spool) SetLogger(logger *log.Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+}
+
+// SetClock replaces the time source used to expire entries
+func (s *Spool) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// logf logs through the configured logger
+func (s *Spool) logf(format string, args ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Dir returns the spool directory, empty when the spool is in memory only
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// UserDir returns the per-user spool directory, creating it if needed
+func (s *Spool) UserDir(username string) (string, error) {
+	if s.dir == "" {
+		return "", fmt.Errorf("no spool directory configured")
+	}
+	dir := filepath.Join(s.dir, filepath.Base(username))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return dir, nil
+}
+
+func (s *Spool) journalPath(username string) string {
+	return filepath.Join(s.dir, filepath.Base(username), "uploads.jsonl")
+}
+
+// NewID returns a unique ID for an upload
+func NewID(now time.Time, name string) string {
+	return fmt.Sprintf("%d-%s", now.UnixNano(), name)
+}
+
+// Record stores a new entry or a status update of an existing one
+func (s *Spool) Record(username string, entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+
+	entries := s.entries[username]
+	replaced := false
+	for i := range entries {
+		if entries[i].ID == entry.ID {
+			entries[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		entries = append(entries, entry)
+	}
+	s.entries[username] = entries
+
+	if s.dir == "" {
+		s.pruneLocked(username)
+		return
+	}
+
+	if err := s.appendLocked(username, entry); err != nil {
+		s.logf("Failed to write upload journal for %s: %v", username, err)
+	}
+
+	// Rewrite the journal from time to time so it only holds live entries
+	s.appends[username]++
+	if s.appends[username] >= 100 {
+		s.pruneLocked(username)
+		if err := s.compactLocked(username); err != nil {
+			s.logf("Failed to compact upload journal for %s: %v", username, err)
+		}
+	}
+}
+
+// Recent returns the user's entries in folder since the given time, newest first
+func (s *Spool) Recent(username, folder string, since time.Time) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+
+	var recent []Entry
+	for _, entry := range s.entries[username] {
+		if entry.Folder == folder && !entry.Time.Before(since) {
+			recent = append(recent, entry)
+		}
+	}
+
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Time.After(recent[j].Time) })
+	return recent
+}
+
+// Get returns a single entry by ID
+func (s *Spool) Get(username, id string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadLocked(username)
+	for _, entry := range s.entries[username] {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// loadLocked reads a user's journal from disk the first time it is needed
+func (s *Spool) loadLocked(username string) {
+	if s.loaded[username] || s.dir == "" {
+		return
+	}
+	s.loaded[username] = true
+
+	file, err := os.Open(s.journalPath(username))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logf("Failed to read upload journal for %s: %v", username, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	byID := make(map[string]int)
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if i, ok := byID[entry.ID]; ok {
+			entries[i] = entry
+			continue
+		}
+		byID[entry.ID] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	s.entries[username] = entries
+	s.pruneLocked(username)
+}
+
+func (s *Spool) pruneLocked(username string) {
+	if s.retention <= 0 {
+		return
+	}
+
+	cutoff := s.now().Add(-s.retention)
+	entries := s.entries[username]
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Time.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	s.entries[username] = kept
+}
+
+func (s *Spool) appendLocked(username string, entry Entry) error {
+	if err := os.MkdirAll(filepath.Dir(s.journalPath(username)), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.journalPath(username), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *Spool) compactLocked(username string) error {
+	s.appends[username] = 0
+
+	tmp := s.journalPath(username) + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, entry := range s.entries[username] {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.journalPath(username))
+}
@@ -0,0 +1,264 @@
+package spool
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSpool(t *testing.T, onDisk bool) *Spool {
+	t.Helper()
+	dir := ""
+	if onDisk {
+		dir = t.TempDir()
+	}
+	s, err := New(dir, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJournal(t *testing.T) {
+	now := time.Now()
+	for _, onDisk := range []bool{false, true} {
+		t.Run(fmt.Sprintf("on disk %v", onDisk), func(t *testing.T) {
+			s := newSpool(t, onDisk)
+
+			s.Record("mika", Entry{ID: "1", Folder: "/in", Name: "a.csv", Time: now.Add(-2 * time.Hour), Status: StatusPending})
+			s.Record("mika", Entry{ID: "2", Folder: "/in", Name: "b.csv", Time: now.Add(-time.Hour), Status: StatusPending})
+			s.Record("mika", Entry{ID: "3", Folder: "/in/orders", Name: "c.csv", Time: now, Status: StatusPending})
+			s.Record("mika", Entry{ID: "old", Folder: "/in", Name: "old.csv", Time: now.Add(-48 * time.Hour), Status: StatusDelivered})
+			s.Record("mika", Entry{ID: "1", Folder: "/in", Name: "a.csv", Time: now.Add(-2 * time.Hour), Status: StatusFailed, Reason: "HTTP 400"})
+			s.Record("liisa", Entry{ID: "4", Folder: "/in", Name: "d.csv", Time: now, Status: StatusPending})
+
+			check := func(s *Spool) {
+				t.Helper()
+				recent := s.Recent("mika", "/in", now.Add(-3*time.Hour))
+				var names []string
+				for _, entry := range recent {
+					names = append(names, entry.Name+":"+entry.Status)
+				}
+				if got, want := strings.Join(names, " "), "b.csv:pending a.csv:failed"; got != want {
+					t.Errorf("Recent = %s, want %s", got, want)
+				}
+				if entry, ok := s.Get("mika", "1"); !ok || entry.Reason != "HTTP 400" {
+					t.Errorf("Get = %+v, %v", entry, ok)
+				}
+				if _, ok := s.Get("mika", "4"); ok {
+					t.Error("entry of another user returned")
+				}
+			}
+			check(s)
+
+			if onDisk {
+				// A restarted service reads the journal back
+				reopened, err := New(s.Dir(), 24*time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				check(reopened)
+			}
+		})
+	}
+}
+
+func TestSpoolClockAndLogger(t *testing.T) {
+	s := newSpool(t, false)
+	past := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.SetClock(func() time.Time { return past })
+
+	// Entries are expired against the spool's clock, not the wall clock
+	s.Record("mika", Entry{ID: "1", Folder: "/in", Name: "a.csv", Time: past.Add(-time.Hour), Status: StatusPending})
+	s.Record("mika", Entry{ID: "2", Folder: "/in", Name: "b.csv", Time: past.Add(-25 * time.Hour), Status: StatusPending})
+	if _, ok := s.Get("mika", "1"); !ok {
+		t.Error("entry within the retention of the clock dropped")
+	}
+	if _, ok := s.Get("mika", "2"); ok {
+		t.Error("entry past the retention of the clock kept")
+	}
+
+	s = newSpool(t, true)
+	var logs strings.Builder
+	s.SetLogger(log.New(&logs, "", 0))
+	if err := os.MkdirAll(s.heldDir("mika"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(s.heldDir("mika"), "broken.json"), []byte("{"), 0600)
+	if _, err := s.LoadHeld(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), "Failed to parse held file") {
+		t.Errorf("log output = %q", logs.String())
+	}
+}
+
+func TestJournalSkipsBrokenLines(t *testing.T) {
+	s := newSpool(t, true)
+	now := time.Now()
+	s.Record("mika", Entry{ID: "1", Folder: "/in", Name: "a.csv", Time: now, Status: StatusPending})
+
+	file, err := os.OpenFile(s.journalPath("mika"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("{\"id\": \"2\", truncated\n")
+	file.Close()
+
+	reopened, _ := New(s.Dir(), 24*time.Hour)
+	if recent := reopened.Recent("mika", "/in", now.Add(-time.Minute)); len(recent) != 1 || recent[0].ID != "1" {
+		t.Errorf("Recent = %+v", recent)
+	}
+}
+
+func TestAreaFiles(t *testing.T) {
+	at := time.Date(2026, 3, 4, 10, 20, 30, 0, time.UTC)
+	for _, onDisk := range []bool{false, true} {
+		t.Run(fmt.Sprintf("on disk %v", onDisk), func(t *testing.T) {
+			s := newSpool(t, onDisk)
+
+			first, err := s.Reject("mika", "order.csv", []byte("data"), []byte("HTTP 400"), at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, _ := s.Reject("mika", "../order.csv", []byte("again"), []byte("HTTP 422"), at)
+			if first != "20260304T102030Z_order.csv" || second != "20260304T102030Z_1_order.csv" {
+				t.Errorf("stored names = %s, %s", first, second)
+			}
+			report, _ := s.AddReport("mika", "order.csv.report.json", []byte("{}"), at)
+
+			files, _ := s.Files(AreaRejected, "mika")
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name)
+			}
+			want := []string{second, second + ReasonSuffix, first, first + ReasonSuffix}
+			if strings.Join(names, " ") != strings.Join(want, " ") {
+				t.Errorf("Files = %v, want %v", names, want)
+			}
+			if reports, _ := s.Files(AreaReports, "mika"); len(reports) != 1 || reports[0].Name != report {
+				t.Errorf("reports = %+v", reports)
+			}
+
+			if data, err := s.ReadFile(AreaRejected, "mika", first+ReasonSuffix); err != nil || string(data) != "HTTP 400" {
+				t.Errorf("ReadFile = %q, %v", data, err)
+			}
+			if file, err := s.StatFile(AreaRejected, "mika", second); err != nil || file.Size != 5 {
+				t.Errorf("StatFile = %+v, %v", file, err)
+			}
+			for _, name := range []string{"", ".", "..", "../reports/" + report, `..\x`} {
+				if _, err := s.ReadFile(AreaRejected, "mika", name); !os.IsNotExist(err) {
+					t.Errorf("ReadFile(%q) error = %v", name, err)
+				}
+				if err := s.RemoveFile(AreaRejected, "mika", name); !os.IsNotExist(err) {
+					t.Errorf("RemoveFile(%q) error = %v", name, err)
+				}
+			}
+			if _, err := s.ReadFile(AreaRejected, "liisa", first); !os.IsNotExist(err) {
+				t.Errorf("file of another user read: %v", err)
+			}
+
+			if err := s.RemoveFile(AreaRejected, "mika", first); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.RemoveFile(AreaRejected, "mika", first); !os.IsNotExist(err) {
+				t.Errorf("second RemoveFile error = %v", err)
+			}
+			if _, err := s.StatFile(AreaRejected, "mika", first); !os.IsNotExist(err) {
+				t.Errorf("removed file still listed: %v", err)
+			}
+
+			if onDisk {
+				if _, err := os.Stat(filepath.Join(s.Dir(), "mika", AreaRejected, first+ReasonSuffix)); err != nil {
+					t.Errorf("reason file not on disk: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryFilesAreBounded(t *testing.T) {
+	s := newSpool(t, false)
+	start := time.Now()
+
+	// Files past the retention are dropped when new ones arrive
+	s.AddReport("mika", "expired.json", []byte("{}"), start.Add(-25*time.Hour))
+	s.AddReport("mika", "fresh.json", []byte("{}"), start)
+	if files, _ := s.Files(AreaReports, "mika"); len(files) != 1 || !strings.HasSuffix(files[0].Name, "fresh.json") {
+		t.Fatalf("files after expiry = %+v", files)
+	}
+
+	for i := 0; i < maxMemoryFiles; i++ {
+		s.Reject("mika", fmt.Sprintf("order%03d.csv", i), []byte("x"), []byte("HTTP 400"), start.Add(time.Duration(i)*time.Second))
+	}
+	files, _ := s.Files(AreaRejected, "mika")
+	if len(files) != maxMemoryFiles {
+		t.Fatalf("kept %d files, want %d", len(files), maxMemoryFiles)
+	}
+	// The oldest rejections went together with their reason files
+	for _, file := range files {
+		if strings.Contains(file.Name, "order099.csv") {
+			t.Fatalf("old file %s kept", file.Name)
+		}
+	}
+	if _, err := s.StatFile(AreaRejected, "mika", files[0].Name); err != nil {
+		t.Error(err)
+	}
+	if reports, _ := s.Files(AreaReports, "mika"); len(reports) != 1 {
+		t.Errorf("other area affected: %d reports", len(reports))
+	}
+}
+
+func TestHold(t *testing.T) {
+	now := time.Now()
+	for _, onDisk := range []bool{false, true} {
+		t.Run(fmt.Sprintf("on disk %v", onDisk), func(t *testing.T) {
+			s := newSpool(t, onDisk)
+			for i, folder := range []string{"/in/batch", "/in/batch", "/in"} {
+				entry := Entry{ID: NewID(now.Add(time.Duration(i)), "order.csv"), Folder: folder, Name: "order.csv", Time: now.Add(time.Duration(i) * time.Second)}
+				if err := s.Hold("mika", HeldFile{Entry: entry, Data: []byte{byte(i)}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			held := s.Held("mika", "/in/batch")
+			if len(held) != 2 || held[0].Data[0] != 0 || held[1].Data[0] != 1 {
+				t.Fatalf("Held = %+v", held)
+			}
+			if len(s.Held("liisa", "/in/batch")) != 0 {
+				t.Error("files of another user returned")
+			}
+
+			taken, ok := s.TakeHeld("mika", held[0].Entry.ID)
+			if !ok || taken.Entry.ID != held[0].Entry.ID {
+				t.Fatalf("TakeHeld = %+v, %v", taken, ok)
+			}
+			if _, ok := s.TakeHeld("mika", held[0].Entry.ID); ok {
+				t.Error("file taken twice")
+			}
+
+			if !onDisk {
+				if loaded, err := s.LoadHeld(); err != nil || len(loaded) != 0 {
+					t.Errorf("LoadHeld in memory = %v, %v", loaded, err)
+				}
+				return
+			}
+			// Files still held survive a restart, taken ones do not
+			reopened, _ := New(s.Dir(), 24*time.Hour)
+			loaded, err := reopened.LoadHeld()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded["mika"]) != 2 {
+				t.Fatalf("LoadHeld = %+v", loaded)
+			}
+			if held := reopened.Held("mika", "/in/batch"); len(held) != 1 || held[0].Data[0] != 1 {
+				t.Errorf("Held after restart = %+v", held)
+			}
+		})
+	}
+}
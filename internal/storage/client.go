@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// Client calls the web API on behalf of a server. A nil Client logs to the
+// standard logger and stamps requests with time.Now.
+type Client struct {
+	Logger *log.Logger      // Log output, the standard logger when nil
+	Clock  func() time.Time // Time of the request timestamps, time.Now when nil
+}
+
+// logf logs through the configured logger
+func (c *Client) logf(format string, args ...interface{}) {
+	if c != nil && c.Logger != nil {
+		c.Logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// now returns the current time from the configured clock
+func (c *Client) now() time.Time {
+	if c != nil && c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
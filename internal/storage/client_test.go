@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientLoggerAndClock(t *testing.T) {
+	var received []OrderRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var order OrderRequest
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &order)
+		received = append(received, order)
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	client := &Client{
+		Logger: log.New(&logs, "", 0),
+		Clock:  func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local) },
+	}
+	if err := client.SendFileToAPI(server.URL, "/api/futur/order", "order", "mika", "key", "order.csv", []byte("1001;2\n"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendBatchToAPI(server.URL, "/api/futur/order/batch", "order", "mika", "key", "order.done", []BatchFile{{Filename: "order.csv"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 2 || received[0].Timestamp != "20200102_030405" || received[1].Timestamp != "20200102_030405" {
+		t.Errorf("timestamps not taken from the clock: %+v", received)
+	}
+	if !strings.Contains(logs.String(), "Sending file to API") || !strings.Contains(logs.String(), "Sending batch to API") {
+		t.Errorf("requests not logged to the client's logger: %q", logs.String())
+	}
+
+	// A nil client falls back to the standard logger and time.Now
+	var none *Client
+	if now := none.now(); time.Since(now) > time.Minute {
+		t.Errorf("nil client time = %s", now)
+	}
+}
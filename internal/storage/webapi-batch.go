@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// BatchFile is one file of a batch delivered with SendBatchToAPI
+type BatchFile struct {
+	Filename string
+	Data     []byte
+	Metadata *UploadMetadata // Nil sends the file without metadata
+}
+
+// BatchRequest delivers several uploads that must be processed together
+type BatchRequest struct {
+	EnvelopeVersion int            `json:"envelope_version"`
+	Username        string         `json:"username"`
+	Batch           string         `json:"batch"` // Trigger or manifest that committed the batch
+	Timestamp       string         `json:"timestamp"`
+	Files           []OrderRequest `json:"files"`
+}
+
+// SendBatchToAPI delivers a batch of files to the given API endpoint as a single request.
+// The files use the order or base64 envelope; the API accepts or rejects them as a whole.
+func (c *Client) SendBatchToAPI(apiURL, endpoint, envelope, username, apiKey, batch string, files []BatchFile) error {
+	timestamp := c.now().Format("20060102_150405")
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	batchReq := BatchRequest{
+		EnvelopeVersion: CurrentEnvelopeVersion,
+		Username:        username,
+		Batch:           batch,
+		Timestamp:       timestamp,
+	}
+	size := 0
+	for _, file := range files {
+		orderReq := OrderRequest{
+			Username:  username,
+			Filename:  file.Filename,
+			Content:   string(file.Data),
+			Timestamp: timestamp,
+			FileSize:  len(file.Data),
+			Metadata:  file.Metadata,
+		}
+		if envelope == "base64" {
+			orderReq.Content = base64.StdEncoding.EncodeToString(file.Data)
+			orderReq.ContentEncoding = "base64"
+		}
+		batchReq.Files = append(batchReq.Files, orderReq)
+		size += len(file.Data)
+	}
+
+	body, err := json.Marshal(batchReq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	url := fmt.Sprintf("%s%s", apiURL, endpoint)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SFTP-Service/1.0")
+	req.Header.Set("X-ApiKey", apiKey)
+
+	c.logf("Sending batch to API: %s (user: %s, batch: %s, %d files)", url, username, batch, len(files))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	c.logf("Batch successfully sent to API: %s", string(respBody))
+	c.logf("Successfully processed batch: %s/%s (%d files, %d bytes)", username, batch, len(files), size)
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type documentEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Type     string    `json:"type,omitempty"`
+}
+
+type documentListResponse struct {
+	Documents []documentEntry `json:"documents"`
+}
+
+// ListDocuments fetches the documents (order confirmations, rejections etc.) addressed to the user
+func (c *Client) ListDocuments(baseURL, username, apiKey string) ([]FileInfo, error) {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	url := fmt.Sprintf("%s/api/futur/documents", baseURL)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("X-ApiKey", apiKey)
+	req.Header.Set("User-Agent", "SFTP-Service/1.0")
+
+	c.logf("Listing documents for user %s from web API: %s", username, url)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed: HTTP %d", resp.StatusCode)
+	}
+
+	var listResp documentListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode document list: %w", err)
+	}
+
+	files := make([]FileInfo, 0, len(listResp.Documents))
+	for _, doc := range listResp.Documents {
+		files = append(files, FileInfo{
+			Name:         doc.Name,
+			Size:         doc.Size,
+			LastModified: doc.Modified,
+		})
+	}
+
+	return files, nil
+}
+
+// OpenDocument starts streaming a document from the web API, beginning at the given byte offset.
+// It returns io.EOF when the offset is at the end of the document.
+func (c *Client) OpenDocument(baseURL, username, apiKey, name string, offset int64) (io.ReadCloser, error) {
+	// Downloads that outlast the timeout are resumed by the caller with a new range request
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	url := fmt.Sprintf("%s/api/futur/documents/%s", baseURL, url.PathEscape(name))
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("X-ApiKey", apiKey)
+	req.Header.Set("User-Agent", "SFTP-Service/1.0")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	c.logf("Downloading document %s for user %s from web API (offset %d)", name, username, offset)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK && offset > 0:
+		// Server ignored the Range header, skip to the requested offset ourselves
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to seek document: %w", err)
+		}
+	case resp.StatusCode == http.StatusOK, resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The offset is at or past the end of the document
+		resp.Body.Close()
+		return nil, io.EOF
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("API request failed: HTTP %d", resp.StatusCode)
+	}
+
+	return resp.Body, nil
+}
+
+// AcknowledgeDocument marks a document as handled so it no longer appears in listings
+func (c *Client) AcknowledgeDocument(baseURL, username, apiKey, name string) error {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	url := fmt.Sprintf("%s/api/futur/documents/%s/ack", baseURL, url.PathEscape(name))
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("X-ApiKey", apiKey)
+	req.Header.Set("User-Agent", "SFTP-Service/1.0")
+
+	c.logf("Acknowledging document %s for user %s: %s", name, username, url)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API request failed: HTTP %d - %s", resp.StatusCode, string(body))
+	}
+
+	c.logf("Document acknowledged: %s/%s", username, name)
+	return nil
+}
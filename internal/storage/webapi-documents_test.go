@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOpenDocument(t *testing.T) {
+	const document = "order confirmation 1001\n"
+	client := &Client{Logger: log.New(io.Discard, "", 0)}
+
+	tests := []struct {
+		name        string
+		offset      int64
+		ignoreRange bool
+		want        string
+		wantErr     error
+	}{
+		{"whole document", 0, false, document, nil},
+		{"range", 6, false, document[6:], nil},
+		{"range ignored by the server", 6, true, document[6:], nil},
+		{"offset at the end", int64(len(document)), false, "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/futur/documents/order 1001.txt" || r.Header.Get("X-ApiKey") != "key" {
+					http.NotFound(w, r)
+					return
+				}
+				start := 0
+				if header := r.Header.Get("Range"); header != "" && !tt.ignoreRange {
+					start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, "bytes="), "-"))
+					if start >= len(document) {
+						w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+						return
+					}
+					w.WriteHeader(http.StatusPartialContent)
+				}
+				io.WriteString(w, document[start:])
+			}))
+			defer server.Close()
+
+			body, err := client.OpenDocument(server.URL, "mika", "key", "order 1001.txt", tt.offset)
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Fatalf("OpenDocument error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			if got, _ := io.ReadAll(body); string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	if _, err := client.OpenDocument(server.URL, "mika", "key", "missing.txt", 0); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("missing document error = %v", err)
+	}
+}
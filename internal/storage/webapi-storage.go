@@ -2,10 +2,10 @@ package storage
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"time"
 )
@@ -18,7 +18,7 @@ type FileInfo struct {
 }
 
 // DownloadPricelist fetches pricelist data from the web API with all parameters
-func DownloadPricelist(baseURL, username, apiKey, remotePath string) ([]byte, error) {
+func (c *Client) DownloadPricelist(baseURL, username, apiKey, remotePath string) ([]byte, error) {
 	// Only allow access to the specific pricelist file
 	if remotePath != "/Hinnat/salhydro_kaikki.zip" && remotePath != "salhydro_kaikki.zip" {
 		return nil, fmt.Errorf("access denied: only salhydro_kaikki.zip is available")
@@ -39,7 +39,7 @@ func DownloadPricelist(baseURL, username, apiKey, remotePath string) ([]byte, er
 	req.Header.Set("X-ApiKey", apiKey)
 	req.Header.Set("User-Agent", "SFTP-Service/1.0")
 
-	log.Printf("Downloading pricelist for user %s from web API: %s", username, url)
+	c.logf("Downloading pricelist for user %s from web API: %s", username, url)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -57,74 +57,164 @@ func DownloadPricelist(baseURL, username, apiKey, remotePath string) ([]byte, er
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	log.Printf("Successfully downloaded pricelist: %d bytes", len(data))
+	c.logf("Successfully downloaded pricelist: %d bytes", len(data))
 	return data, nil
 }
 
+// CurrentEnvelopeVersion is the envelope version sent with uploads unless a folder asks for the legacy format
+const CurrentEnvelopeVersion = 2
+
 type OrderRequest struct {
-	Username  string `json:"username"`
-	Filename  string `json:"filename"`
-	Content   string `json:"content"`
-	Timestamp string `json:"timestamp"`
-	FileSize  int    `json:"file_size"`
+	EnvelopeVersion int             `json:"envelope_version,omitempty"`
+	Username        string          `json:"username"`
+	Filename        string          `json:"filename"`
+	Content         string          `json:"content"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	Timestamp       string          `json:"timestamp"`
+	FileSize        int             `json:"file_size"`
+	Metadata        *UploadMetadata `json:"metadata,omitempty"`
+	DryRun          bool            `json:"dry_run,omitempty"` // Validate only, never create an order
+}
+
+// UploadMetadata describes where an upload came from, sent with envelope version 2 and later
+type UploadMetadata struct {
+	UploadedAt    string `json:"uploaded_at"` // RFC 3339, UTC
+	SessionID     string `json:"session_id"`
+	RemoteAddr    string `json:"remote_addr"`
+	ClientVersion string `json:"client_version"`
+	SHA256        string `json:"sha256"` // Of the original bytes, before normalization
+	ContentType   string `json:"content_type"`
+	Encoding      string `json:"encoding"` // Source encoding detected or declared for the upload
+	Path          string `json:"path"`     // Virtual path the file was uploaded to
+}
+
+// APIError is returned when the API answers an upload with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed: HTTP %d - %s", e.StatusCode, e.Body)
+}
+
+// Rejected reports whether the API refused the file itself (4xx) rather than failing to process it
+func (e *APIError) Rejected() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// SendFileToAPI delivers an uploaded file to the given API endpoint wrapped in the requested envelope.
+// A nil metadata sends the legacy (version 1) envelope.
+func (c *Client) SendFileToAPI(apiURL, endpoint, envelope, username, apiKey, filename string, data []byte, metadata *UploadMetadata) error {
+	respBody, err := c.sendFile(apiURL, endpoint, envelope, username, apiKey, filename, data, metadata, false)
+	if err != nil {
+		return err
+	}
+
+	c.logf("File successfully sent to API: %s", string(respBody))
+	c.logf("Successfully processed incoming file: %s/%s (%d bytes)", username, filename, len(data))
+	return nil
 }
 
-// SendOrderToAPI sends the order data to the HTTP API with all parameters
-func SendOrderToAPI(apiURL, username, apiKey, filename, content string) error {
-	// Check file size limit (100KB = 102400 bytes)
-	if len(content) > 102400 {
-		return fmt.Errorf("file size exceeds 100KB limit")
+// DryRunFileToAPI sends an upload to the API's dry-run endpoint and returns the API's answer.
+// The request is flagged as a dry run so it must never create an order.
+func (c *Client) DryRunFileToAPI(apiURL, endpoint, envelope, username, apiKey, filename string, data []byte, metadata *UploadMetadata) (string, error) {
+	respBody, err := c.sendFile(apiURL, endpoint, envelope, username, apiKey, filename, data, metadata, true)
+	if err != nil {
+		return "", err
 	}
 
+	c.logf("Dry run answered by API: %s/%s: %s", username, filename, string(respBody))
+	return string(respBody), nil
+}
+
+// sendFile posts an upload in the requested envelope and returns the response body
+func (c *Client) sendFile(apiURL, endpoint, envelope, username, apiKey, filename string, data []byte, metadata *UploadMetadata, dryRun bool) ([]byte, error) {
 	// Generate timestamp for the order
-	timestamp := time.Now().Format("20060102_150405")
+	timestamp := c.now().Format("20060102_150405")
 
 	// Create local HTTP client
 	client := &http.Client{
 		Timeout: 30 * time.Second,
 	}
 
+	var body []byte
+	contentType := "application/json"
+
 	orderReq := OrderRequest{
 		Username:  username,
 		Filename:  filename,
-		Content:   content,
+		Content:   string(data),
 		Timestamp: timestamp,
-		FileSize:  len(content),
+		FileSize:  len(data),
+		DryRun:    dryRun,
+	}
+	if metadata != nil {
+		orderReq.EnvelopeVersion = CurrentEnvelopeVersion
+		orderReq.Metadata = metadata
 	}
 
-	jsonData, err := json.Marshal(orderReq)
-	if err != nil {
-		return fmt.Errorf("failed to marshal order: %w", err)
+	switch envelope {
+	case "raw":
+		body = data
+		contentType = "application/octet-stream"
+	case "base64":
+		orderReq.Content = base64.StdEncoding.EncodeToString(data)
+		orderReq.ContentEncoding = "base64"
+		fallthrough
+	default:
+		jsonData, err := json.Marshal(orderReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal order: %w", err)
+		}
+		body = jsonData
 	}
 
-	url := fmt.Sprintf("%s/api/futur/order", apiURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	url := fmt.Sprintf("%s%s", apiURL, endpoint)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("User-Agent", "SFTP-Service/1.0")
 	req.Header.Set("X-ApiKey", apiKey)
+	if dryRun {
+		req.Header.Set("X-Dry-Run", "true")
+	}
+	if envelope == "raw" {
+		req.Header.Set("X-Username", username)
+		req.Header.Set("X-Filename", filename)
+		req.Header.Set("X-Timestamp", timestamp)
+		if metadata != nil {
+			req.Header.Set("X-Envelope-Version", fmt.Sprint(CurrentEnvelopeVersion))
+			req.Header.Set("X-Upload-Time", metadata.UploadedAt)
+			req.Header.Set("X-Session-Id", metadata.SessionID)
+			req.Header.Set("X-Remote-Addr", metadata.RemoteAddr)
+			req.Header.Set("X-Client-Version", metadata.ClientVersion)
+			req.Header.Set("X-Content-Sha256", metadata.SHA256)
+			req.Header.Set("X-Upload-Content-Type", metadata.ContentType)
+			req.Header.Set("X-Upload-Encoding", metadata.Encoding)
+			req.Header.Set("X-Upload-Path", metadata.Path)
+		}
+	}
 
-	log.Printf("Sending order to API: %s (user: %s, file: %s)", url, username, filename)
+	c.logf("Sending file to API: %s (user: %s, file: %s)", url, username, filename)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("API request failed: HTTP %d - %s", resp.StatusCode, string(body))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
-	log.Printf("Order successfully sent to API: %s", string(body))
-	log.Printf("Successfully processed incoming order: %s/%s (%d bytes)", username, filename, len(content))
-	return nil
+	return respBody, nil
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"sftp-service/internal/auth"
 	"sftp-service/internal/config"
+	"sftp-service/internal/proxyproto"
 	"sftp-service/internal/sftp"
 )
 
@@ -27,8 +31,48 @@ func main() {
 	sftpServer, err := sftp.NewServer(&sftp.Config{
 		Authenticator: authenticator,
 		BaseURL:       cfg.FuturAPIURL,
-		HostKeyPath:   cfg.SFTPHostKeyPath,
-		Port:          cfg.SFTPPort,
+		HostKeys: sftp.HostKeys{
+			Paths:        cfg.SFTPHostKeyPaths,
+			PEM:          []byte(cfg.SFTPHostKeys),
+			Generate:     cfg.SFTPHostKeyGenerate,
+			Certificates: cfg.SFTPHostCerts,
+		},
+		Port:             cfg.SFTPPort,
+		InboundFolders:   cfg.InboundFolders,
+		SpoolDir:         cfg.SpoolDir,
+		SpoolRetention:   cfg.SpoolRetention,
+		ListingWindow:    cfg.ListingWindow,
+		ListingStatus:    cfg.ListingStatus,
+		AuditDir:         cfg.AuditDir,
+		PGPKeyFile:       cfg.PGPKeyFile,
+		PGPPassphrase:    cfg.PGPPassphrase,
+		PricelistTTL:     cfg.PricelistTTL,
+		PricelistDir:     cfg.PricelistDir,
+		PricelistKeep:    cfg.PricelistKeep,
+		PricelistFormats: cfg.PricelistFormats,
+		Users:            cfg.Users,
+		Limits: sftp.Limits{
+			MaxConnections:           cfg.MaxConnections,
+			MaxConnectionsPerIP:      cfg.MaxConnectionsIP,
+			MaxSessionsPerUser:       cfg.MaxUserSessions,
+			MaxChannelsPerConnection: cfg.MaxChannels,
+		},
+		Crypto: sftp.CryptoPolicy{
+			Profile:      cfg.CryptoProfile,
+			KeyExchanges: cfg.KexAlgorithms,
+			Ciphers:      cfg.Ciphers,
+			MACs:         cfg.MACs,
+			PublicKeys:   cfg.PublicKeyAlgorithms,
+			AuditProfile: cfg.CryptoAudit,
+		},
+		ProxyProtocol: proxyproto.Config{Trusted: cfg.ProxyTrusted, Timeout: cfg.ProxyHeaderTimeout},
+		Timeouts: sftp.Timeouts{
+			Handshake:         cfg.HandshakeTimeout,
+			Idle:              cfg.IdleTimeout,
+			MaxSession:        cfg.MaxSessionDuration,
+			KeepaliveInterval: cfg.KeepaliveInterval,
+			KeepaliveCountMax: cfg.KeepaliveCountMax,
+		},
 	})
 	if err != nil {
 		log.Fatalf("Failed to create SFTP server: %v", err)
@@ -38,14 +82,33 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	// Connection counts for monitoring
+	if cfg.StatusAddr != "" {
+		go func() {
+			log.Printf("Serving status on %s/status", cfg.StatusAddr)
+			mux := http.NewServeMux()
+			mux.Handle("/status", sftpServer.StatusHandler())
+			if err := http.ListenAndServe(cfg.StatusAddr, mux); err != nil {
+				log.Printf("Status server error: %v", err)
+			}
+		}()
+	}
+
 	// Start server in a goroutine
 	go func() {
-		if err := sftpServer.Start(); err != nil {
+		if err := sftpServer.Start(); err != nil && !errors.Is(err, sftp.ErrServerClosed) {
 			log.Fatalf("SFTP server error: %v", err)
 		}
 	}()
 
 	// Wait for shutdown signal
 	<-c
-	log.Println("Shutting down SFTP service...")
+	log.Printf("Shutting down SFTP service, waiting up to %s for transfers to finish...", cfg.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := sftpServer.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown incomplete: %v", err)
+	}
+	log.Println("SFTP service stopped")
 }